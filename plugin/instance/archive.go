@@ -0,0 +1,126 @@
+package instance
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+// findArchivesPage returns a page of the search results, replaced in tests
+var findArchivesPage = func(client *api.Client, archiveAPI *api.ArchiveAPI, offset int) (*sacloud.SearchResponse, error) {
+	return archiveAPI.Limit(searchPageSize).Offset(offset).Find()
+}
+
+// findArchives returns all archives having the tags in the zone of the client
+var findArchives = func(client *api.Client, tags []string) ([]sacloud.Archive, error) {
+	archives := []sacloud.Archive{}
+	err := pageThrough(func(offset int) (int, int, error) {
+		// use a dedicated API object because search conditions are stored in it
+		archiveAPI := api.NewArchiveAPI(client)
+		if len(tags) > 0 {
+			archiveAPI.WithTags(tags)
+		}
+
+		res, err := findArchivesPage(client, archiveAPI, offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		archives = append(archives, res.Archives...)
+		return len(res.Archives), res.Total, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+func validateArchiveSelector(params instance_types.Properties) []error {
+	selector := params.SourceArchive
+	if selector == nil {
+		return []error{}
+	}
+
+	errs := []error{}
+	if params.DiskMode != "create" {
+		errs = append(errs, validateConflictValues("DiskMode", params.DiskMode, map[string]interface{}{"SourceArchive": selector})...)
+	}
+	errs = append(errs, validateConflicts("SourceArchive", selector, map[string]interface{}{"SourceArchiveID": params.SourceArchiveID})...)
+	errs = append(errs, validateConflicts("SourceArchive", selector, map[string]interface{}{"SourceDiskID": params.SourceDiskID})...)
+	errs = append(errs, validateConflicts("SourceArchive", selector, map[string]interface{}{"OSType": params.OSType})...)
+
+	if selector.Name == "" && len(selector.Tags) == 0 {
+		errs = append(errs, fmt.Errorf("%q: Name or Tags is required", "SourceArchive"))
+	}
+	if _, err := path.Match(selector.Name, ""); err != nil {
+		errs = append(errs, fmt.Errorf("%q: invalid pattern %q: %s", "SourceArchive.Name", selector.Name, err))
+	}
+	return errs
+}
+
+// selectArchive returns the archive matching the selector.
+// Multiple matches are an error unless the selector asks for the latest one.
+func selectArchive(archives []sacloud.Archive, selector *instance_types.ArchiveSelector) (*sacloud.Archive, error) {
+	matched := []sacloud.Archive{}
+	for _, a := range archives {
+		if !a.IsAvailable() {
+			continue
+		}
+		if selector.Name != "" {
+			if ok, _ := path.Match(selector.Name, a.GetName()); !ok {
+				continue
+			}
+		}
+		hasTags := true
+		for _, tag := range selector.Tags {
+			hasTags = hasTags && a.HasTag(tag)
+		}
+		if hasTags {
+			matched = append(matched, a)
+		}
+	}
+
+	switch {
+	case len(matched) == 0:
+		return nil, fmt.Errorf("no archive matches %s", formatArchiveSelector(selector))
+	case len(matched) > 1 && !selector.Latest:
+		ids := []int64{}
+		for _, a := range matched {
+			ids = append(ids, a.ID)
+		}
+		return nil, fmt.Errorf("multiple archives match %s: %v", formatArchiveSelector(selector), ids)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i].GetCreatedAt(), matched[j].GetCreatedAt()
+		return a != nil && (b == nil || a.After(*b))
+	})
+	return &matched[0], nil
+}
+
+func formatArchiveSelector(selector *instance_types.ArchiveSelector) string {
+	return fmt.Sprintf("{Name: %q, Tags: %v}", selector.Name, selector.Tags)
+}
+
+// resolveSourceArchive returns the Properties with SourceArchiveID of the archive selected by SourceArchive
+func resolveSourceArchive(client *api.Client, params instance_types.Properties) (instance_types.Properties, error) {
+	if params.SourceArchive == nil {
+		return params, nil
+	}
+
+	archives, err := findArchives(client, params.SourceArchive.Tags)
+	if err != nil {
+		return params, fmt.Errorf("%q: finding archives is failed: %s", "SourceArchive", err)
+	}
+	archive, err := selectArchive(archives, params.SourceArchive)
+	if err != nil {
+		return params, fmt.Errorf("%q: %s in zone %s", "SourceArchive", err, client.Zone)
+	}
+
+	params.SourceArchiveID = archive.ID
+	params.SourceArchive = nil
+	return params, nil
+}
@@ -0,0 +1,118 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestArchive(id int64, name string, createdAt time.Time, tags ...string) sacloud.Archive {
+	archive := sacloud.Archive{Resource: &sacloud.Resource{ID: id}}
+	archive.Name = name
+	archive.Availability = sacloud.EAAvailable
+	archive.CreatedAt = &createdAt
+	archive.Tags = tags
+	return archive
+}
+
+func TestSelectArchive(t *testing.T) {
+	now := time.Now()
+	archives := []sacloud.Archive{
+		newTestArchive(300000000001, "web-20170101", now.Add(-48*time.Hour), "web"),
+		newTestArchive(300000000002, "web-20170102", now.Add(-24*time.Hour), "web", "stable"),
+		newTestArchive(300000000003, "web-20170103", now, "web"),
+		newTestArchive(300000000004, "db-20170103", now, "db"),
+	}
+	archives[2].Availability = sacloud.EAMigrating
+
+	a, err := selectArchive(archives, &instance_types.ArchiveSelector{Name: "web-*", Latest: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300000000002), a.ID)
+
+	a, err = selectArchive(archives, &instance_types.ArchiveSelector{Tags: []string{"web", "stable"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300000000002), a.ID)
+
+	_, err = selectArchive(archives, &instance_types.ArchiveSelector{Tags: []string{"web"}})
+	assert.EqualError(t, err, `multiple archives match {Name: "", Tags: [web]}: [300000000001 300000000002]`)
+
+	_, err = selectArchive(archives, &instance_types.ArchiveSelector{Name: "web", Latest: true})
+	assert.EqualError(t, err, `no archive matches {Name: "web", Tags: []}`)
+}
+
+func TestValidateArchiveSelector(t *testing.T) {
+	params := instance_types.Properties{
+		DiskMode:      "create",
+		SourceArchive: &instance_types.ArchiveSelector{Name: "web-*"},
+	}
+	assert.Empty(t, validateArchiveSelector(params))
+
+	params.OSType = "centos"
+	params.SourceArchive = &instance_types.ArchiveSelector{Name: "web-["}
+	errs := validateArchiveSelector(params)
+	assert.Len(t, errs, 2)
+	assert.EqualError(t, errs[0], `"SourceArchive": is conflict with "OSType"`)
+
+	params = instance_types.Properties{
+		DiskMode:      "connect",
+		SourceArchive: &instance_types.ArchiveSelector{},
+	}
+	errs = validateArchiveSelector(params)
+	assert.Len(t, errs, 2)
+	assert.EqualError(t, errs[1], `"SourceArchive": Name or Tags is required`)
+}
+
+func TestResolveSourceArchive(t *testing.T) {
+	original := findArchives
+	defer func() { findArchives = original }()
+
+	findArchives = func(client *api.Client, tags []string) ([]sacloud.Archive, error) {
+		if client.Zone != "tk1a" {
+			return []sacloud.Archive{}, nil
+		}
+		return []sacloud.Archive{newTestArchive(300000000001, "web-20170101", time.Now(), "web")}, nil
+	}
+
+	params := instance_types.Properties{
+		DiskMode:      "create",
+		SourceArchive: &instance_types.ArchiveSelector{Tags: []string{"web"}},
+	}
+
+	resolved, err := resolveSourceArchive(api.NewClient("token", "secret", "tk1a"), params)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300000000001), resolved.SourceArchiveID)
+	assert.Nil(t, resolved.SourceArchive)
+
+	_, err = resolveSourceArchive(api.NewClient("token", "secret", "is1b"), params)
+	assert.EqualError(t, err, `"SourceArchive": no archive matches {Name: "", Tags: [web]} in zone is1b`)
+}
+
+func TestFindArchives(t *testing.T) {
+	original := findArchivesPage
+	defer func() { findArchivesPage = original }()
+
+	// the API returns full pages beyond the total
+	offsets := []int{}
+	findArchivesPage = func(client *api.Client, archiveAPI *api.ArchiveAPI, offset int) (*sacloud.SearchResponse, error) {
+		offsets = append(offsets, offset)
+		archives := []sacloud.Archive{}
+		for i := 0; i < searchPageSize; i++ {
+			archives = append(archives, newTestArchive(int64(300000000000+offset+i), "web", time.Now()))
+		}
+		return &sacloud.SearchResponse{
+			Total:                   150,
+			From:                    offset,
+			Count:                   len(archives),
+			SakuraCloudResourceList: &sacloud.SakuraCloudResourceList{Archives: archives},
+		}, nil
+	}
+
+	archives, err := findArchives(api.NewClient("token", "secret", "tk1a"), []string{"web"})
+	assert.NoError(t, err)
+	assert.Len(t, archives, 200)
+	assert.Equal(t, []int{0, 100}, offsets)
+}
@@ -0,0 +1,217 @@
+package instance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+const (
+	// attachmentTypeDisk connects an existing disk to the server
+	attachmentTypeDisk = "disk"
+
+	// attachmentTypeSwitch adds a NIC connected to an existing switch
+	attachmentTypeSwitch = "switch"
+
+	// attachmentTypePacketFilter applies an existing packet filter to the first NIC
+	attachmentTypePacketFilter = "packetfilter"
+
+	// attachmentTypeCDROM inserts an existing ISO image
+	attachmentTypeCDROM = "cdrom"
+)
+
+var attachmentTypes = []string{attachmentTypeDisk, attachmentTypeSwitch, attachmentTypePacketFilter, attachmentTypeCDROM}
+
+// validateAttachments validates instance.Spec.Attachments with the Properties
+func validateAttachments(attachments []instance.Attachment, params instance_types.Properties) []error {
+	errs := []error{}
+	counts := map[string]int{}
+
+	for i, a := range attachments {
+		fieldName := fmt.Sprintf("Attachments[%d]", i)
+		errs = append(errs, validateInStrValues(fieldName+".Type", a.Type, attachmentTypes...)...)
+		errs = append(errs, validateRequired(fieldName+".Type", a.Type)...)
+
+		id, err := strconv.ParseInt(a.ID, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: Resource ID must be a number", fieldName+".ID"))
+			continue
+		}
+		errs = append(errs, validateRequired(fieldName+".ID", id)...)
+		errs = append(errs, validateSakuraID(fieldName+".ID", id)...)
+		counts[a.Type]++
+	}
+
+	if counts[attachmentTypeDisk] > 0 {
+		disks := counts[attachmentTypeDisk] + len(params.AdditionalDisks)
+		if params.DiskMode != "diskless" {
+			disks++
+		}
+		if disks > sacloud.ServerMaxDiskLen {
+			errs = append(errs, fmt.Errorf("%q: up to %d disks can be connected to a server including the boot disk and AdditionalDisks", "Attachments(disk)", sacloud.ServerMaxDiskLen))
+		}
+	}
+	if counts[attachmentTypeCDROM] > 0 {
+		errs = append(errs, validateConflicts("Attachments(cdrom)", counts[attachmentTypeCDROM], map[string]interface{}{"ISOImageID": params.ISOImageID})...)
+		if counts[attachmentTypeCDROM] > 1 {
+			errs = append(errs, fmt.Errorf("%q: only one ISO image can be inserted", "Attachments(cdrom)"))
+		}
+	}
+	if counts[attachmentTypePacketFilter] > 0 {
+		eth0PacketFilterID := int64(0)
+		if nics := params.NetworkInterfaces(); len(nics) > 0 {
+			eth0PacketFilterID = nics[0].PacketFilterID
+		}
+		errs = append(errs, validateConflicts("Attachments(packetfilter)", counts[attachmentTypePacketFilter], map[string]interface{}{"PacketFilterID": eth0PacketFilterID})...)
+		if counts[attachmentTypePacketFilter] > 1 {
+			errs = append(errs, fmt.Errorf("%q: only one packet filter can be applied", "Attachments(packetfilter)"))
+		}
+	}
+	return errs
+}
+
+// formatAttachments returns the compact form of attachments to be stored in the instance tags, such as "disk:112233445566"
+func formatAttachments(attachments []instance.Attachment) string {
+	s := []string{}
+	for _, a := range attachments {
+		s = append(s, a.Type+":"+a.ID)
+	}
+	return strings.Join(s, ",")
+}
+
+// parseAttachments parses the form returned by formatAttachments
+func parseAttachments(s string) []instance.Attachment {
+	attachments := []instance.Attachment{}
+	for _, v := range strings.Split(s, ",") {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		attachments = append(attachments, instance.Attachment{Type: parts[0], ID: parts[1]})
+	}
+	return attachments
+}
+
+// attachResources attaches resources to the server, which must be down
+func attachResources(client *api.Client, serverID int64, attachments []instance.Attachment) error {
+	// NICs for switches are added before applying packet filters
+	for _, a := range attachments {
+		id, _ := strconv.ParseInt(a.ID, 10, 64)
+
+		switch a.Type {
+		case attachmentTypeDisk:
+			if _, err := client.GetDiskAPI().ConnectToServer(id, serverID); err != nil {
+				return fmt.Errorf("Attaching disk %d is failed: %s", id, err)
+			}
+		case attachmentTypeSwitch:
+			nic, err := client.GetInterfaceAPI().CreateAndConnectToServer(serverID)
+			if err != nil {
+				return fmt.Errorf("Adding NIC for switch %d is failed: %s", id, err)
+			}
+			if _, err := client.GetInterfaceAPI().ConnectToSwitch(nic.ID, id); err != nil {
+				return fmt.Errorf("Attaching switch %d is failed: %s", id, err)
+			}
+		}
+	}
+
+	server, err := client.GetServerAPI().Read(serverID)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		id, _ := strconv.ParseInt(a.ID, 10, 64)
+
+		switch a.Type {
+		case attachmentTypePacketFilter:
+			if len(server.Interfaces) == 0 {
+				return fmt.Errorf("Attaching packet filter %d is failed: server has no NIC", id)
+			}
+			if _, err := client.GetInterfaceAPI().ConnectToPacketFilter(server.Interfaces[0].ID, id); err != nil {
+				return fmt.Errorf("Attaching packet filter %d is failed: %s", id, err)
+			}
+		case attachmentTypeCDROM:
+			if _, err := client.GetServerAPI().InsertCDROM(serverID, id); err != nil {
+				return fmt.Errorf("Inserting ISO image %d is failed: %s", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// detachResources detaches switches, packet filters and ISO images attached to the server.
+// Attached disks are detached with other disks not created by the plugin.
+func detachResources(client *api.Client, server *sacloud.Server, attachments []instance.Attachment) error {
+	for _, a := range currentAttachments(server, attachments) {
+		id, _ := strconv.ParseInt(a.ID, 10, 64)
+
+		switch a.Type {
+		case attachmentTypeSwitch:
+			for _, nic := range server.Interfaces {
+				if nic.Switch != nil && nic.Switch.Resource != nil && nic.Switch.ID == id {
+					if _, err := client.GetInterfaceAPI().DisconnectFromSwitch(nic.ID); err != nil {
+						return fmt.Errorf("Detaching switch %d is failed: %s", id, err)
+					}
+				}
+			}
+		case attachmentTypePacketFilter:
+			for _, nic := range server.Interfaces {
+				if nic.PacketFilter != nil && nic.PacketFilter.Resource != nil && nic.PacketFilter.ID == id {
+					if _, err := client.GetInterfaceAPI().DisconnectFromPacketFilter(nic.ID); err != nil {
+						return fmt.Errorf("Detaching packet filter %d is failed: %s", id, err)
+					}
+				}
+			}
+		case attachmentTypeCDROM:
+			if _, err := client.GetServerAPI().EjectCDROM(server.ID, id); err != nil {
+				return fmt.Errorf("Ejecting ISO image %d is failed: %s", id, err)
+			}
+		default:
+			continue
+		}
+		log.Infof("%s %d is detached from server %d", a.Type, id, server.ID)
+	}
+	return nil
+}
+
+// currentAttachments returns the attachments still attached to the server
+func currentAttachments(server *sacloud.Server, attachments []instance.Attachment) []instance.Attachment {
+	attached := map[string]bool{}
+	for _, d := range server.Disks {
+		attached[attachmentTypeDisk+":"+strconv.FormatInt(d.ID, 10)] = true
+	}
+	for _, nic := range server.Interfaces {
+		if nic.Switch != nil && nic.Switch.Resource != nil {
+			attached[attachmentTypeSwitch+":"+strconv.FormatInt(nic.Switch.ID, 10)] = true
+		}
+		if nic.PacketFilter != nil && nic.PacketFilter.Resource != nil {
+			attached[attachmentTypePacketFilter+":"+strconv.FormatInt(nic.PacketFilter.ID, 10)] = true
+		}
+	}
+	if server.Instance != nil && server.Instance.CDROM != nil && server.Instance.CDROM.Resource != nil {
+		attached[attachmentTypeCDROM+":"+strconv.FormatInt(server.Instance.CDROM.ID, 10)] = true
+	}
+
+	current := []instance.Attachment{}
+	for _, a := range attachments {
+		if attached[a.Type+":"+a.ID] {
+			current = append(current, a)
+		}
+	}
+	return current
+}
+
+// bootServer boots the server and waits until it is up
+func bootServer(client *api.Client, id int64) error {
+	serverAPI := client.GetServerAPI()
+	if _, err := serverAPI.Boot(id); err != nil {
+		return fmt.Errorf("Booting server is failed: %s", err)
+	}
+	return serverAPI.SleepUntilUp(id, client.DefaultTimeoutDuration)
+}
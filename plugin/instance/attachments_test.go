@@ -0,0 +1,57 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/docker/infrakit/pkg/spi/instance"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAttachments(t *testing.T) {
+	attachments := []instance.Attachment{
+		{Type: attachmentTypeDisk, ID: "112233445566"},
+		{Type: attachmentTypeSwitch, ID: "112233445567"},
+		{Type: attachmentTypeCDROM, ID: "112233445568"},
+	}
+	assert.Empty(t, validateAttachments(attachments, instance_types.Properties{}))
+
+	// conflicts with ISOImageID
+	assert.NotEmpty(t, validateAttachments(attachments, instance_types.Properties{ISOImageID: 112233445569}))
+
+	// exceeds the disk limit with the boot disk and additional disks
+	assert.NotEmpty(t, validateAttachments(attachments, instance_types.Properties{
+		DiskMode:        "create",
+		AdditionalDisks: make([]instance_types.AdditionalDisk, 3),
+	}))
+
+	assert.NotEmpty(t, validateAttachments([]instance.Attachment{{Type: "volume", ID: "112233445566"}}, instance_types.Properties{}))
+	assert.NotEmpty(t, validateAttachments([]instance.Attachment{{Type: attachmentTypeDisk, ID: "foo"}}, instance_types.Properties{}))
+	assert.NotEmpty(t, validateAttachments([]instance.Attachment{{Type: attachmentTypeDisk, ID: "1"}}, instance_types.Properties{}))
+}
+
+func TestFormatAttachments(t *testing.T) {
+	attachments := []instance.Attachment{
+		{Type: attachmentTypeDisk, ID: "112233445566"},
+		{Type: attachmentTypePacketFilter, ID: "112233445567"},
+	}
+	s := formatAttachments(attachments)
+	assert.Equal(t, "disk:112233445566,packetfilter:112233445567", s)
+	assert.Equal(t, attachments, parseAttachments(s))
+	assert.Equal(t, []instance.Attachment{}, parseAttachments(""))
+}
+
+func TestCurrentAttachments(t *testing.T) {
+	server := newTestServer(map[string]string{}, 112233445566)
+	server.Interfaces = []sacloud.Interface{
+		{Switch: &sacloud.Switch{Resource: &sacloud.Resource{ID: 112233445567}}},
+	}
+
+	attachments := []instance.Attachment{
+		{Type: attachmentTypeDisk, ID: "112233445566"},
+		{Type: attachmentTypeSwitch, ID: "112233445567"},
+		{Type: attachmentTypeCDROM, ID: "112233445568"},
+	}
+	assert.Equal(t, attachments[:2], currentAttachments(server, attachments))
+}
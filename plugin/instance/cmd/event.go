@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/docker/infrakit/pkg/spi"
+	"github.com/docker/infrakit/pkg/spi/event"
+	"github.com/docker/infrakit/pkg/types"
+)
+
+// The vendored infrakit has no rpc/event package, the RPC service of the Event SPI is implemented here.
+// Events are published through the event.Publisher and event.Validator of the service by the rpc server.
+
+// ListRequest is the rpc wrapper for the List request
+type ListRequest struct {
+	Topic types.Path
+}
+
+// ListResponse is the rpc wrapper for the List response
+type ListResponse struct {
+	Nodes []string
+}
+
+// Event is the rpc service of the event plugin
+type Event struct {
+	plugin event.Plugin
+}
+
+func eventPluginServer(p event.Plugin) *Event {
+	return &Event{plugin: p}
+}
+
+// ImplementedInterface returns the interface implemented by this RPC service.
+func (e *Event) ImplementedInterface() spi.InterfaceSpec {
+	return event.InterfaceSpec
+}
+
+// Types returns the types exposed by this service
+func (e *Event) Types() []string {
+	return []string{"."}
+}
+
+// List returns a list of child topics given a topic.
+func (e *Event) List(_ *http.Request, req *ListRequest, resp *ListResponse) error {
+	nodes, err := e.plugin.List(req.Topic)
+	if err != nil {
+		return err
+	}
+	resp.Nodes = nodes
+	return nil
+}
+
+// PublishOn sets the channel to publish events of the plugin
+func (e *Event) PublishOn(ch chan<- *event.Event) {
+	if p, ok := e.plugin.(event.Publisher); ok {
+		p.PublishOn(ch)
+	}
+}
+
+// Validate validates the topic to subscribe
+func (e *Event) Validate(topic types.Path) error {
+	if v, ok := e.plugin.(event.Validator); ok {
+		return v.Validate(topic)
+	}
+	return nil
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/infrakit/pkg/cli"
 	instance_plugin "github.com/docker/infrakit/pkg/rpc/instance"
+	metadata_plugin "github.com/docker/infrakit/pkg/rpc/metadata"
 	"github.com/sacloud/infrakit.sakuracloud/plugin/instance"
 	"github.com/sacloud/infrakit.sakuracloud/version"
 	"github.com/sacloud/libsacloud/api"
@@ -28,6 +31,24 @@ func main() {
 	accessToken := cmd.Flags().String("token", "", "SakuraCloud token")
 	accessSecret := cmd.Flags().String("secret", "", "SakuraCloud secret")
 	zone := cmd.Flags().String("zone", "is1b", "SakuraCloud zone")
+	zones := cmd.Flags().StringSlice("zones", []string{}, "A list of additional SakuraCloud zones to manage instances in")
+
+	shutdownTimeout := cmd.Flags().Duration("shutdown-timeout", 1*time.Minute,
+		"Time to wait for graceful shutdown before forcing to power off on destroy. 0 means powering off immediately")
+	rollingUpdateShutdownTimeout := cmd.Flags().Duration("rolling-update-shutdown-timeout", 0,
+		"Shutdown timeout on destroy for rolling update (default: value of --shutdown-timeout)")
+	terminationShutdownTimeout := cmd.Flags().Duration("termination-shutdown-timeout", 0,
+		"Shutdown timeout on destroy for termination (default: value of --shutdown-timeout)")
+	keyDir := cmd.Flags().String("key-dir", filepath.Join(os.Getenv("HOME"), ".infrakit", "sakuracloud", "keys"),
+		"Directory to store private keys generated with GenerateSSHKey")
+
+	healthMode := cmd.Flags().String("health-mode", "flag",
+		"How to report failed, stuck or down servers: none, flag(with the infrakit-health tag) or exclude")
+	healthGracePeriod := cmd.Flags().Duration("health-grace-period", 10*time.Minute,
+		"Time after creation while servers migrating or down are regarded as being built")
+	autoBootRetries := cmd.Flags().Int("auto-boot-retries", 0,
+		"Number of times to boot servers down on their own before regarding them as unhealthy. 0 disables auto-boot")
+	maxProvisions := cmd.Flags().Int("max-provisions", 0, "Maximum number of concurrent provisions. 0 means unlimited")
 
 	if accessToken == nil || *accessToken == "" {
 		v := os.Getenv("SAKURACLOUD_ACCESS_TOKEN")
@@ -62,16 +83,52 @@ func main() {
 		}
 		for k, v := range requires {
 			if v == nil || *v == "" {
-				log.Errorln("%q is required", k)
+				log.Errorf("%q is required", k)
 				os.Exit(1)
 			}
 		}
 
+		validHealthMode := false
+		for _, m := range instance.HealthModes {
+			validHealthMode = validHealthMode || m == *healthMode
+		}
+		if !validHealthMode {
+			log.Errorf("%q must be one of %v", "health-mode", instance.HealthModes)
+			os.Exit(1)
+		}
+
 		client := api.NewClient(*accessToken, *accessSecret, *zone)
 
 		client.UserAgent = fmt.Sprintf("infrakit-instance-sakuracloud:%s", version.Version)
 
-		cli.RunPlugin(*name, instance_plugin.PluginServer(instance.NewSakuraCloudInstancePlugin(client, namespace)))
+		options := instance.Options{
+			Zones:         *zones,
+			KeyDir:        *keyDir,
+			MaxProvisions: *maxProvisions,
+			HealthPolicy: instance.HealthPolicy{
+				Mode:            *healthMode,
+				GracePeriod:     *healthGracePeriod,
+				AutoBootRetries: *autoBootRetries,
+			},
+			ShutdownPolicy: instance.ShutdownPolicy{
+				Timeout:              *shutdownTimeout,
+				RollingUpdateTimeout: *shutdownTimeout,
+				TerminationTimeout:   *shutdownTimeout,
+			},
+		}
+		if c.Flags().Changed("rolling-update-shutdown-timeout") {
+			options.ShutdownPolicy.RollingUpdateTimeout = *rollingUpdateShutdownTimeout
+		}
+		if c.Flags().Changed("termination-shutdown-timeout") {
+			options.ShutdownPolicy.TerminationTimeout = *terminationShutdownTimeout
+		}
+
+		p := instance.NewSakuraCloudInstancePlugin(client, namespace, options)
+		cli.RunPlugin(*name,
+			instance_plugin.PluginServer(p),
+			metadata_plugin.UpdatablePluginServer(instance.MetadataPlugin(p)),
+			eventPluginServer(instance.EventPlugin(p)),
+		)
 	}
 
 	cmd.AddCommand(cli.VersionCommand())
@@ -0,0 +1,248 @@
+package instance
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/metadata"
+	"github.com/docker/infrakit/pkg/types"
+)
+
+// Config is the runtime configuration of the plugin, changed through metadata.Updatable under the "config" node
+type Config struct {
+	// NamespaceTags scope all resources created by the plugin
+	NamespaceTags map[string]string
+
+	// MaxProvisions is the maximum number of concurrent provisions. Zero means unlimited.
+	MaxProvisions int
+
+	// DefaultProperties are partial Properties merged under the Properties of every spec
+	DefaultProperties *types.Any
+}
+
+// configFields are the fields of Config which can be changed
+var configFields = []string{"NamespaceTags", "MaxProvisions", "DefaultProperties"}
+
+// currentConfig returns the current Config. The caller must hold p.lock.
+func (p *plugin) currentConfig() Config {
+	return Config{
+		NamespaceTags:     p.namespaceTags,
+		MaxProvisions:     p.maxProvisions,
+		DefaultProperties: p.defaultProperties,
+	}
+}
+
+// namespace returns the current namespace tags
+func (p *plugin) namespace() map[string]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.namespaceTags
+}
+
+// Changes applies the changes to the current Config, and returns the original and the proposed Config with a cas hash.
+// Paths of changes are under the "config" node, such as "config/MaxProvisions".
+func (p *plugin) Changes(changes []metadata.Change) (original, proposed *types.Any, cas string, err error) {
+	p.lock.Lock()
+	original, err = types.AnyValue(p.currentConfig())
+	p.lock.Unlock()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	config := map[string]interface{}{}
+	if err = original.Decode(&config); err != nil {
+		return nil, nil, "", err
+	}
+	for _, change := range changes {
+		if err = applyConfigChange(config, change); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	proposed, err = types.AnyValue(config)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if _, err = p.parseConfig(proposed); err != nil {
+		return nil, nil, "", err
+	}
+	return original, proposed, types.Fingerprint(original, proposed), nil
+}
+
+// Commit applies the proposed Config, if the current Config is not changed since the proposal
+func (p *plugin) Commit(proposed *types.Any, cas string) error {
+	config, err := p.parseConfig(proposed)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	original, err := types.AnyValue(p.currentConfig())
+	if err != nil {
+		return err
+	}
+	if types.Fingerprint(original, proposed) != cas {
+		return fmt.Errorf("config is changed since the proposal, cas %s doesn't match", cas)
+	}
+
+	p.namespaceTags = config.NamespaceTags
+	p.maxProvisions = config.MaxProvisions
+	p.defaultProperties = config.DefaultProperties
+	p.provisionDone.Broadcast()
+
+	log.Infof("Config is committed(cas: %s)", cas)
+	return nil
+}
+
+func applyConfigChange(config map[string]interface{}, change metadata.Change) error {
+	path := metadataPath(change.Path)
+	if len(path) < 2 || path[0] != "config" {
+		return fmt.Errorf("%q is read-only", path.String())
+	}
+	if !isConfigField(path[1]) {
+		return fmt.Errorf("%q is unknown, changeable fields are %v", path.String(), configFields)
+	}
+
+	var value interface{}
+	if change.Value != nil {
+		if err := change.Value.Decode(&value); err != nil {
+			return fmt.Errorf("%q: %s", path.String(), err)
+		}
+	}
+
+	object := config
+	for i, key := range path[1 : len(path)-1] {
+		next, ok := object[key].(map[string]interface{})
+		if !ok {
+			if object[key] != nil {
+				return fmt.Errorf("%q: %s is not an object", path.String(), strings.Join(path[1:i+2], "/"))
+			}
+			next = map[string]interface{}{}
+			object[key] = next
+		}
+		object = next
+	}
+	object[path[len(path)-1]] = value
+	return nil
+}
+
+func isConfigField(field string) bool {
+	for _, f := range configFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfig decodes the Config, and validates it with the same validators as specs
+func (p *plugin) parseConfig(any *types.Any) (Config, error) {
+	config := Config{}
+	if err := any.Decode(&config); err != nil {
+		return config, fmt.Errorf("invalid config: %s", err)
+	}
+
+	errs := []error{}
+	for k := range config.NamespaceTags {
+		if k == "" {
+			errs = append(errs, fmt.Errorf("%q: empty key is not allowed", "NamespaceTags"))
+		}
+	}
+	if config.MaxProvisions < 0 {
+		errs = append(errs, fmt.Errorf("%q: must be zero(unlimited) or more", "MaxProvisions"))
+	}
+	if config.DefaultProperties != nil {
+		if err := config.DefaultProperties.Decode(&map[string]interface{}{}); err != nil {
+			errs = append(errs, fmt.Errorf("%q: must be an object: %s", "DefaultProperties", err))
+		} else if err := p.validateSpec(config.DefaultProperties); err != nil {
+			// DefaultProperties are validated as the Properties of a spec without its own Properties
+			errs = append(errs, fmt.Errorf("%q: %s", "DefaultProperties", err))
+		}
+	}
+	if err := p.validateNamespaceChange(config.NamespaceTags); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return config, flattenErrors(errs)
+	}
+	return config, nil
+}
+
+// validateNamespaceChange rejects changes of NamespaceTags while instances exist in the current namespace,
+// they would be left out of Describe and Destroy
+func (p *plugin) validateNamespaceChange(namespaceTags map[string]string) error {
+	current := p.namespace()
+	if reflect.DeepEqual(current, namespaceTags) || len(current) == 0 && len(namespaceTags) == 0 {
+		return nil
+	}
+	_, tags := mergeTags(current)
+	count := 0
+	for _, zone := range p.managedZones() {
+		// all zones must be checked, unlike DescribeInstances
+		descriptions, err := p.describeZone(zone, tags, false, false)
+		if err != nil {
+			return fmt.Errorf("%q: %s", "NamespaceTags", err)
+		}
+		count += len(descriptions)
+	}
+	if count > 0 {
+		return fmt.Errorf("%q: can't be changed while %d instances exist in the current namespace", "NamespaceTags", count)
+	}
+	return nil
+}
+
+// withDefaultProperties returns the Properties with DefaultProperties merged under them
+func (p *plugin) withDefaultProperties(req *types.Any) (*types.Any, error) {
+	p.lock.Lock()
+	defaults := p.defaultProperties
+	p.lock.Unlock()
+
+	if defaults == nil {
+		return req, nil
+	}
+
+	merged := map[string]interface{}{}
+	if err := defaults.Decode(&merged); err != nil {
+		return nil, err
+	}
+	properties := map[string]interface{}{}
+	if req != nil {
+		if err := req.Decode(&properties); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range properties {
+		// keys of JSON objects are case-insensitive
+		for d := range merged {
+			if strings.EqualFold(d, k) {
+				delete(merged, d)
+			}
+		}
+		merged[k] = v
+	}
+	return types.AnyValue(merged)
+}
+
+// beginProvision waits while MaxProvisions provisions are running
+func (p *plugin) beginProvision() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for p.maxProvisions > 0 && p.provisions >= p.maxProvisions {
+		p.provisionDone.Wait()
+	}
+	p.provisions++
+}
+
+func (p *plugin) endProvision() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.provisions--
+	p.provisionDone.Broadcast()
+}
@@ -0,0 +1,156 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit/pkg/spi/metadata"
+	"github.com/docker/infrakit/pkg/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func newConfigTestPlugin() *plugin {
+	return NewSakuraCloudInstancePlugin(api.NewClient("token", "secret", "tk1a"), map[string]string{"cluster": "etcd"}, Options{}).(*plugin)
+}
+
+// stubConfigTestAPI stubs API calls of the config validation, with the number of instances in tk1a.
+// The archive 112233445566 exists.
+func stubConfigTestAPI(instances int) func() {
+	restoreServers := stubZoneServers(map[string]int{"tk1a": instances})
+	_, restoreReaders := stubResourceReaders(112233445566)
+	original := fetchProductCatalog
+	fetchProductCatalog = func(client *api.Client) (*productCatalog, error) {
+		return testCatalog(), nil
+	}
+	return func() {
+		restoreServers()
+		restoreReaders()
+		fetchProductCatalog = original
+	}
+}
+
+func change(path string, value interface{}) metadata.Change {
+	return metadata.Change{Path: types.PathFromString(path), Value: types.AnyValueMust(value)}
+}
+
+func TestConfigChanges(t *testing.T) {
+	defer stubConfigTestAPI(0)()
+	p := newConfigTestPlugin()
+
+	original, proposed, cas, err := p.Changes([]metadata.Change{
+		change("config/MaxProvisions", 3),
+		change("config/NamespaceTags/env", "prod"),
+		change("config/DefaultProperties/Core", 2),
+		change("config/DefaultProperties/Memory", 2),
+		change("config/DefaultProperties/SourceArchiveID", 112233445566),
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cas)
+
+	config := Config{}
+	assert.NoError(t, original.Decode(&config))
+	assert.Equal(t, 0, config.MaxProvisions)
+
+	// nothing is changed until the commit
+	assert.Equal(t, 0, p.maxProvisions)
+
+	assert.NoError(t, p.Commit(proposed, cas))
+	assert.Equal(t, 3, p.maxProvisions)
+	assert.Equal(t, map[string]string{"cluster": "etcd", "env": "prod"}, p.namespace())
+	defaults := map[string]interface{}{}
+	assert.NoError(t, p.defaultProperties.Decode(&defaults))
+	assert.Equal(t, map[string]interface{}{"Core": float64(2), "Memory": float64(2), "SourceArchiveID": float64(112233445566)}, defaults)
+
+	// the proposal is stale after the commit
+	assert.Error(t, p.Commit(proposed, cas))
+}
+
+func TestConfigChangesRejected(t *testing.T) {
+	defer stubConfigTestAPI(0)()
+	p := newConfigTestPlugin()
+	p.defaultProperties = types.AnyString(`{"SourceArchiveID":112233445566}`)
+
+	_, _, _, err := p.Changes([]metadata.Change{change("config/DefaultProperties/Memory", 4)})
+	assert.NoError(t, err)
+
+	for _, c := range []metadata.Change{
+		change("zone", "is1a"),
+		change("config/Unknown", 1),
+		change("config/MaxProvisions", -1),
+		change("config/MaxProvisions/Foo", 1),
+		change("config/NamespaceTags", map[string]string{"": "etcd"}),
+		change("config/DefaultProperties", "Core"),
+		change("config/DefaultProperties/Core", "two"),
+		change("config/DefaultProperties/DestroyPolicy/Mode", "unknown"),
+		change("config/DefaultProperties/Zones", []string{"is1a"}),
+		// validated as specs
+		change("config/DefaultProperties/DiskMode", "unknown"),
+		change("config/DefaultProperties/OSType", "unknown"),
+		change("config/DefaultProperties/NetworkMode", "switch"),
+		change("config/DefaultProperties/Core", 3),
+	} {
+		_, _, _, err := p.Changes([]metadata.Change{c})
+		assert.Error(t, err, c.Path.String())
+	}
+}
+
+func TestNamespaceTagsChange(t *testing.T) {
+	p := newConfigTestPlugin()
+
+	restore := stubConfigTestAPI(2)
+	_, _, _, err := p.Changes([]metadata.Change{change("config/NamespaceTags/env", "prod")})
+	assert.EqualError(t, err, `"NamespaceTags": can't be changed while 2 instances exist in the current namespace`)
+
+	// other fields can be changed
+	_, _, _, err = p.Changes([]metadata.Change{change("config/MaxProvisions", 3)})
+	assert.NoError(t, err)
+	restore()
+
+	defer stubConfigTestAPI(0)()
+	_, _, _, err = p.Changes([]metadata.Change{change("config/NamespaceTags/env", "prod")})
+	assert.NoError(t, err)
+}
+
+func TestWithDefaultProperties(t *testing.T) {
+	p := newConfigTestPlugin()
+
+	req := types.AnyString(`{"core":4,"Memory":8}`)
+	merged, err := p.withDefaultProperties(req)
+	assert.NoError(t, err)
+	assert.Equal(t, req, merged)
+
+	p.defaultProperties = types.AnyString(`{"Core":2,"DiskPlan":"hdd"}`)
+	merged, err = p.withDefaultProperties(req)
+	assert.NoError(t, err)
+	properties := map[string]interface{}{}
+	assert.NoError(t, merged.Decode(&properties))
+	assert.Equal(t, map[string]interface{}{"DiskPlan": "hdd", "Memory": float64(8), "core": float64(4)}, properties)
+}
+
+func TestMaxProvisions(t *testing.T) {
+	p := newConfigTestPlugin()
+	p.maxProvisions = 1
+
+	p.beginProvision()
+
+	started := make(chan struct{})
+	go func() {
+		p.beginProvision()
+		close(started)
+		p.endProvision()
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("provision must wait while MaxProvisions provisions are running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.endProvision()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("provision must start after the running provision ends")
+	}
+}
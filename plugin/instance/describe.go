@@ -0,0 +1,74 @@
+package instance
+
+import (
+	"strconv"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+// describeServer returns the properties of the server reported by DescribeInstances
+func describeServer(zone string, server *sacloud.Server) instance_types.Description {
+	d := instance_types.Description{
+		Name:         server.Name,
+		Zone:         zone,
+		Status:       server.GetInstanceStatus(),
+		Availability: string(server.Availability),
+		Networks:     []instance_types.NetworkDescription{},
+		Disks:        []instance_types.DiskDescription{},
+		CreatedAt:    server.CreatedAt,
+	}
+	if server.ServerPlan != nil {
+		d.Core = server.ServerPlan.GetCPU()
+		d.Memory = server.ServerPlan.GetMemoryGB()
+	}
+
+	for _, nic := range server.Interfaces {
+		n := instance_types.NetworkDescription{
+			Type:       "disconnect",
+			IPAddress:  nic.IPAddress,
+			MACAddress: nic.MACAddress,
+		}
+		if nic.Switch != nil && nic.Switch.Resource != nil {
+			if nic.Switch.Scope == sacloud.ESCopeShared {
+				n.Type = "shared"
+			} else {
+				n.Type = "switch"
+				n.SwitchID = nic.Switch.ID
+			}
+		}
+		if n.IPAddress == "" {
+			n.IPAddress = nic.UserIPAddress
+		}
+		if nic.PacketFilter != nil && nic.PacketFilter.Resource != nil {
+			n.PacketFilterID = nic.PacketFilter.ID
+		}
+		d.Networks = append(d.Networks, n)
+	}
+
+	for i := range server.Disks {
+		disk := &server.Disks[i]
+		dd := instance_types.DiskDescription{
+			ID:         disk.ID,
+			Name:       disk.Name,
+			Connection: string(disk.Connection),
+			Size:       disk.GetSizeGB(),
+		}
+		for name, id := range diskPlanIDs {
+			if disk.GetPlanID() == int64(id) {
+				dd.Plan = name
+			}
+		}
+		d.Disks = append(d.Disks, dd)
+
+		if i == 0 && disk.SourceArchive != nil && disk.SourceArchive.Resource != nil {
+			d.SourceArchiveID = disk.SourceArchive.ID
+		}
+	}
+
+	// the archive resolved on Provision is recorded in the tags
+	if id, err := strconv.ParseInt(infrakitTags(server)[instance_types.InfrakitSourceArchive], 10, 64); err == nil {
+		d.SourceArchiveID = id
+	}
+	return d
+}
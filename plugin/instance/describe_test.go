@@ -0,0 +1,64 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeServer(t *testing.T) {
+	createdAt := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	server := newTestServer(map[string]string{
+		instance_types.InfrakitLogicalID: "etcd-1",
+	}, 200000000001, 200000000002)
+	server.Name = "etcd-abcdef"
+	server.Availability = sacloud.EAAvailable
+	server.CreatedAt = &createdAt
+	server.Instance = &sacloud.Instance{EServerInstanceStatus: &sacloud.EServerInstanceStatus{Status: "up"}}
+	server.ServerPlan = &sacloud.ProductServer{}
+	server.ServerPlan.CPU = 2
+	server.ServerPlan.MemoryMB = 4096
+
+	server.Disks[0].Name = "etcd-abcdef"
+	server.Disks[0].SetSizeGB(20)
+	server.Disks[0].Plan = sacloud.DiskPlanSSD
+	server.Disks[0].Connection = sacloud.DiskConnectionVirtio
+	server.Disks[0].SourceArchive = &sacloud.Archive{Resource: &sacloud.Resource{ID: 300000000001}}
+	server.Disks[1].SetSizeGB(100)
+	server.Disks[1].Plan = sacloud.DiskPlanHDD
+
+	shared := sacloud.Interface{MACAddress: "9c:a3:ba:00:00:01", IPAddress: "133.242.0.10"}
+	shared.Switch = &sacloud.Switch{Resource: &sacloud.Resource{ID: 500000000001}, Scope: sacloud.ESCopeShared}
+	private := sacloud.Interface{MACAddress: "9c:a3:ba:00:00:02", UserIPAddress: "192.168.0.11"}
+	private.Switch = &sacloud.Switch{Resource: &sacloud.Resource{ID: 112233445566}, Scope: sacloud.ESCopeUser}
+	disconnected := sacloud.Interface{MACAddress: "9c:a3:ba:00:00:03"}
+	server.Interfaces = []sacloud.Interface{shared, private, disconnected}
+
+	assert.Equal(t, instance_types.Description{
+		Name:         "etcd-abcdef",
+		Zone:         "tk1a",
+		Status:       "up",
+		Availability: "available",
+		Core:         2,
+		Memory:       4,
+		Networks: []instance_types.NetworkDescription{
+			{Type: "shared", IPAddress: "133.242.0.10", MACAddress: "9c:a3:ba:00:00:01"},
+			{Type: "switch", SwitchID: 112233445566, IPAddress: "192.168.0.11", MACAddress: "9c:a3:ba:00:00:02"},
+			{Type: "disconnect", MACAddress: "9c:a3:ba:00:00:03"},
+		},
+		Disks: []instance_types.DiskDescription{
+			{ID: 200000000001, Name: "etcd-abcdef", Plan: "ssd", Connection: "virtio", Size: 20},
+			{ID: 200000000002, Plan: "hdd", Size: 100},
+		},
+		SourceArchiveID: 300000000001,
+		CreatedAt:       &createdAt,
+	}, describeServer("tk1a", server))
+
+	// the resolved archive is recorded in the tags
+	server.Tags = instance_types.EncodeTags(map[string]string{instance_types.InfrakitSourceArchive: "300000000002"})
+	assert.Equal(t, int64(300000000002), describeServer("tk1a", server).SourceArchiveID)
+}
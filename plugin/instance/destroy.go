@@ -0,0 +1,245 @@
+package instance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/builder"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+// ShutdownPolicy controls how servers are shut down on Destroy.
+// The server is shut down via ACPI and is forced to power off if it doesn't go down within the timeout.
+// A zero timeout means powering off immediately.
+type ShutdownPolicy struct {
+	// Timeout is used when no timeout is given for the reason of Destroy
+	Timeout time.Duration
+
+	// RollingUpdateTimeout is used on Destroy with instance.RollingUpdate
+	RollingUpdateTimeout time.Duration
+
+	// TerminationTimeout is used on Destroy with instance.Termination
+	TerminationTimeout time.Duration
+}
+
+// timeoutFor returns the timeout of graceful shutdown for the context
+func (s ShutdownPolicy) timeoutFor(ctx instance.Context) time.Duration {
+	switch ctx.Reason {
+	case instance.RollingUpdate.Reason:
+		return s.RollingUpdateTimeout
+	case instance.Termination.Reason:
+		return s.TerminationTimeout
+	}
+	return s.Timeout
+}
+
+// API calls of shutdownServer, replaced in tests
+var (
+	shutdownGracefully = func(client *api.Client, id int64) error {
+		_, err := client.GetServerAPI().Shutdown(id)
+		return err
+	}
+	shutdownForcibly = func(client *api.Client, id int64) error {
+		_, err := client.GetServerAPI().Stop(id)
+		return err
+	}
+	sleepUntilDown = func(client *api.Client, id int64, timeout time.Duration) error {
+		return client.GetServerAPI().SleepUntilDown(id, timeout)
+	}
+)
+
+// shutdownServer shuts down the server according to the policy, and waits until the server is down
+func shutdownServer(client *api.Client, id int64, policy ShutdownPolicy, ctx instance.Context) error {
+	if timeout := policy.timeoutFor(ctx); timeout > 0 {
+		log.Debugf("shutting down server %d gracefully(timeout: %s)", id, timeout)
+
+		err := shutdownGracefully(client, id)
+		if err == nil {
+			err = sleepUntilDown(client, id, timeout)
+			if err == nil {
+				return nil
+			}
+		}
+		log.Warnf("Graceful shutdown of server %d is failed, forcing to power off: %s", id, err)
+	}
+
+	err := shutdownForcibly(client, id)
+	if err != nil {
+		return fmt.Errorf("Stopping server is failed: %s", err)
+	}
+
+	return sleepUntilDown(client, id, client.DefaultTimeoutDuration)
+}
+
+// markDestroyed tags the server powered off by Destroy, so that it isn't reported by DescribeInstances anymore
+func markDestroyed(client *api.Client, server *sacloud.Server, ctx instance.Context) error {
+	reason := ctx.Reason
+	if reason == "" {
+		reason = "destroyed"
+	}
+
+	_, tags := mergeTags(infrakitTags(server))
+	tags[instance_types.InfrakitDestroyed] = reason
+	setInstanceTags(server, tags)
+
+	_, err := client.GetServerAPI().Update(server.ID, server)
+	return err
+}
+
+// recordCreatedDisks tags the server with IDs of the disks created by the build
+func recordCreatedDisks(client *api.Client, res *builder.ServerBuildResult) error {
+	diskIDs := []int64{}
+	for _, d := range res.Disks {
+		if d != nil && d.Disk != nil {
+			diskIDs = append(diskIDs, d.Disk.ID)
+		}
+	}
+
+	server, err := client.GetServerAPI().Read(res.Server.ID)
+	if err != nil {
+		return err
+	}
+
+	_, tags := mergeTags(infrakitTags(server))
+	tags[instance_types.InfrakitCreatedDisks] = formatDiskIDs(diskIDs)
+	setInstanceTags(server, tags)
+
+	_, err = client.GetServerAPI().Update(server.ID, server)
+	return err
+}
+
+// ownedDiskIDs splits IDs of the disks connected to the server into the ones created by the plugin and the others.
+// All disks are regarded as created by the plugin if the server has no record of them(created by older versions).
+func ownedDiskIDs(server *sacloud.Server) ([]int64, []int64) {
+	record, ok := infrakitTags(server)[instance_types.InfrakitCreatedDisks]
+	if !ok {
+		if len(server.Disks) > 0 {
+			log.Warnf("Server %d has no record of created disks, all of its disks are regarded as created by the plugin", server.ID)
+		}
+		return server.GetDiskIDs(), []int64{}
+	}
+
+	created := map[int64]bool{}
+	for _, id := range parseDiskIDs(record) {
+		created[id] = true
+	}
+
+	owned, others := []int64{}, []int64{}
+	for _, id := range server.GetDiskIDs() {
+		if created[id] {
+			owned = append(owned, id)
+		} else {
+			others = append(others, id)
+		}
+	}
+	return owned, others
+}
+
+// detachDisks disconnects the disks from the server, so that they survive deleting the server
+func detachDisks(client *api.Client, id instance.ID, diskIDs []int64) error {
+	diskAPI := client.GetDiskAPI()
+	for _, diskID := range diskIDs {
+		if _, err := diskAPI.DisconnectFromServer(diskID); err != nil {
+			return fmt.Errorf("Detaching disk %d is failed: %s", diskID, err)
+		}
+		log.Infof("Disk %d is detached from instance %s", diskID, id)
+	}
+	return nil
+}
+
+func formatDiskIDs(ids []int64) string {
+	s := []string{}
+	for _, id := range ids {
+		s = append(s, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(s, ",")
+}
+
+func parseDiskIDs(s string) []int64 {
+	ids := []int64{}
+	for _, v := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// keepDisks tags given disks of the server as orphaned by the instance, so that they can be found after the server is deleted
+func keepDisks(client *api.Client, id instance.ID, server *sacloud.Server, diskIDs []int64) error {
+	orphanTags := map[string]string{
+		instance_types.InfrakitOrphanedFrom: string(id),
+	}
+	if logicalID, ok := infrakitTags(server)[instance_types.InfrakitLogicalID]; ok {
+		orphanTags[instance_types.InfrakitLogicalID] = logicalID
+	}
+
+	diskAPI := client.GetDiskAPI()
+	for _, diskID := range diskIDs {
+		disk, err := diskAPI.Read(diskID)
+		if err != nil {
+			return err
+		}
+		for _, tag := range instance_types.EncodeTags(orphanTags) {
+			disk.AppendTag(tag)
+		}
+		if _, err := diskAPI.Update(disk.ID, disk); err != nil {
+			return err
+		}
+		log.Infof("Disk %d of instance %s is kept", disk.ID, id)
+	}
+	return nil
+}
+
+// ownedBootDiskID returns the ID of the first disk connected to the server among the ones created by the plugin.
+// The second return value is false if there is no such disk, e.g. in connect mode.
+func ownedBootDiskID(server *sacloud.Server) (int64, bool) {
+	owned, _ := ownedDiskIDs(server)
+	for _, disk := range server.Disks {
+		for _, diskID := range owned {
+			if disk.ID == diskID {
+				return diskID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// archiveBootDisk creates an archive from the boot disk of the server, and waits until copying is done.
+// Disks not created by the plugin are never archived.
+func archiveBootDisk(client *api.Client, id instance.ID, server *sacloud.Server) error {
+	diskID, ok := ownedBootDiskID(server)
+	if !ok {
+		log.Warnf("Instance %s has no disk created by the plugin to archive", id)
+		return nil
+	}
+
+	archiveAPI := client.GetArchiveAPI()
+
+	archive := archiveAPI.New()
+	archive.Name = fmt.Sprintf("%s-%s", server.Name, time.Now().Format("20060102150405"))
+	archive.SetSourceDisk(diskID)
+	archive.Tags = instance_types.EncodeTags(map[string]string{
+		instance_types.InfrakitArchivedFrom: string(id),
+	})
+
+	archive, err := archiveAPI.Create(archive)
+	if err != nil {
+		return fmt.Errorf("Creating archive is failed: %s", err)
+	}
+
+	err = archiveAPI.SleepWhileCopying(archive.ID, client.DefaultTimeoutDuration)
+	if err != nil {
+		return fmt.Errorf("Creating archive is failed: %s", err)
+	}
+	log.Infof("Archive %d is created from instance %s", archive.ID, id)
+	return nil
+}
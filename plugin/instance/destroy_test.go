@@ -0,0 +1,125 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit/pkg/spi/instance"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(tags map[string]string, diskIDs ...int64) *sacloud.Server {
+	server := &sacloud.Server{Resource: &sacloud.Resource{ID: 100000000001}}
+	for _, id := range diskIDs {
+		server.Disks = append(server.Disks, sacloud.Disk{Resource: &sacloud.Resource{ID: id}})
+	}
+	server.Tags = instance_types.EncodeTags(tags)
+	return server
+}
+
+func TestOwnedDiskIDs(t *testing.T) {
+	server := newTestServer(map[string]string{
+		instance_types.InfrakitCreatedDisks: formatDiskIDs([]int64{200000000001, 200000000003}),
+	}, 200000000001, 200000000002, 200000000003)
+
+	owned, others := ownedDiskIDs(server)
+	assert.Equal(t, []int64{200000000001, 200000000003}, owned)
+	assert.Equal(t, []int64{200000000002}, others)
+
+	// recorded before the build
+	server = newTestServer(map[string]string{
+		instance_types.InfrakitCreatedDisks: "",
+	}, 200000000001)
+
+	owned, others = ownedDiskIDs(server)
+	assert.Equal(t, []int64{}, owned)
+	assert.Equal(t, []int64{200000000001}, others)
+
+	// created by older versions
+	server = newTestServer(map[string]string{}, 200000000001, 200000000002)
+
+	owned, others = ownedDiskIDs(server)
+	assert.Equal(t, []int64{200000000001, 200000000002}, owned)
+	assert.Equal(t, []int64{}, others)
+}
+
+// stubShutdownAPI records calls of shutdownServer. The server goes down within downIn after the graceful shutdown.
+func stubShutdownAPI(downIn time.Duration, stopErr error) (*[]string, func()) {
+	calls := []string{}
+	origShutdown, origStop, origSleep := shutdownGracefully, shutdownForcibly, sleepUntilDown
+	shutdownGracefully = func(client *api.Client, id int64) error {
+		calls = append(calls, fmt.Sprintf("shutdown %d", id))
+		return nil
+	}
+	shutdownForcibly = func(client *api.Client, id int64) error {
+		calls = append(calls, fmt.Sprintf("stop %d", id))
+		return stopErr
+	}
+	sleepUntilDown = func(client *api.Client, id int64, timeout time.Duration) error {
+		calls = append(calls, fmt.Sprintf("wait %d %s", id, timeout))
+		if len(calls) == 2 && downIn > timeout {
+			return errors.New("Timeout: WaitforAvailable")
+		}
+		return nil
+	}
+	return &calls, func() {
+		shutdownGracefully, shutdownForcibly, sleepUntilDown = origShutdown, origStop, origSleep
+	}
+}
+
+func TestShutdownServer(t *testing.T) {
+	client := api.NewClient("token", "secret", "tk1a")
+	client.DefaultTimeoutDuration = 20 * time.Minute
+	policy := ShutdownPolicy{Timeout: time.Minute}
+
+	// forced to power off after the timeout of graceful shutdown
+	calls, restore := stubShutdownAPI(2*time.Minute, nil)
+	assert.NoError(t, shutdownServer(client, 100000000001, policy, instance.Context{}))
+	assert.Equal(t, []string{
+		"shutdown 100000000001",
+		"wait 100000000001 1m0s",
+		"stop 100000000001",
+		"wait 100000000001 20m0s",
+	}, *calls)
+	restore()
+
+	calls, restore = stubShutdownAPI(30*time.Second, nil)
+	assert.NoError(t, shutdownServer(client, 100000000001, policy, instance.Context{}))
+	assert.Equal(t, []string{"shutdown 100000000001", "wait 100000000001 1m0s"}, *calls)
+	restore()
+
+	// powered off immediately without the timeout
+	calls, restore = stubShutdownAPI(0, nil)
+	assert.NoError(t, shutdownServer(client, 100000000001, ShutdownPolicy{}, instance.Context{}))
+	assert.Equal(t, []string{"stop 100000000001", "wait 100000000001 20m0s"}, *calls)
+	restore()
+
+	calls, restore = stubShutdownAPI(2*time.Minute, errors.New("Error in response"))
+	defer restore()
+	assert.EqualError(t, shutdownServer(client, 100000000001, policy, instance.Context{}), "Stopping server is failed: Error in response")
+	assert.Equal(t, []string{"shutdown 100000000001", "wait 100000000001 1m0s", "stop 100000000001"}, *calls)
+}
+
+func TestArchiveBootDisk(t *testing.T) {
+	server := newTestServer(map[string]string{
+		instance_types.InfrakitCreatedDisks: formatDiskIDs([]int64{200000000002, 200000000003}),
+	}, 200000000001, 200000000002, 200000000003)
+
+	diskID, ok := ownedBootDiskID(server)
+	assert.True(t, ok)
+	assert.Equal(t, int64(200000000002), diskID)
+
+	// connect mode, the disk belongs to the user
+	server = newTestServer(map[string]string{
+		instance_types.InfrakitCreatedDisks: "",
+	}, 200000000001)
+
+	_, ok = ownedBootDiskID(server)
+	assert.False(t, ok)
+	assert.NoError(t, archiveBootDisk(nil, "tk1a/100000000001", server))
+}
@@ -0,0 +1,163 @@
+package instance
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/event"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/docker/infrakit/pkg/types"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+)
+
+var (
+	topicProvisionStart         = types.PathFromString("instance/provision/start")
+	topicProvisionDiskCreated   = types.PathFromString("instance/provision/disk-created")
+	topicProvisionDiskEdited    = types.PathFromString("instance/provision/disk-edited")
+	topicProvisionServerCreated = types.PathFromString("instance/provision/server-created")
+	topicProvisionBooted        = types.PathFromString("instance/provision/booted")
+	topicProvisionFailed        = types.PathFromString("instance/provision/failed")
+
+	topicDestroyStart   = types.PathFromString("instance/destroy/start")
+	topicDestroyStopped = types.PathFromString("instance/destroy/stopped")
+	topicDestroyDeleted = types.PathFromString("instance/destroy/deleted")
+)
+
+// eventTopics is the tree of all topics
+var eventTopics = func() map[string]interface{} {
+	tree := map[string]interface{}{}
+	for _, topic := range []types.Path{
+		topicProvisionStart, topicProvisionDiskCreated, topicProvisionDiskEdited,
+		topicProvisionServerCreated, topicProvisionBooted, topicProvisionFailed,
+		topicDestroyStart, topicDestroyStopped, topicDestroyDeleted,
+	} {
+		types.Put(topic, true, tree)
+	}
+	return tree
+}()
+
+// EventPlugin returns the event plugin of the instance plugin created by NewSakuraCloudInstancePlugin.
+// It also implements event.Publisher and event.Validator.
+func EventPlugin(p instance.Plugin) event.Plugin {
+	if p, ok := p.(*plugin); ok {
+		return p.events
+	}
+	return nil
+}
+
+// eventQueueSize is the number of events queued for a slow subscriber, further events are dropped
+const eventQueueSize = 100
+
+// eventPublisher publishes lifecycle events of instances.
+// Events are dropped until PublishOn is called.
+// Events are queued and sent to the channel by a goroutine, so that Provision and Destroy never wait for subscribers.
+type eventPublisher struct {
+	ch    chan<- *event.Event
+	queue chan *event.Event
+	seq   int64
+	lock  sync.Mutex
+}
+
+// List returns child topics of the topic
+func (e *eventPublisher) List(topic types.Path) ([]string, error) {
+	return types.List(metadataPath(topic), eventTopics), nil
+}
+
+// Validate returns an error if the topic is unknown
+func (e *eventPublisher) Validate(topic types.Path) error {
+	if types.Get(metadataPath(topic), eventTopics) == nil {
+		return fmt.Errorf("unknown topic %s", topic.String())
+	}
+	return nil
+}
+
+// PublishOn sets the channel to publish events
+func (e *eventPublisher) PublishOn(ch chan<- *event.Event) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.ch = ch
+	if e.queue == nil {
+		e.queue = make(chan *event.Event, eventQueueSize)
+		go e.forward(e.queue)
+	}
+}
+
+// forward sends queued events to the channel set by PublishOn
+func (e *eventPublisher) forward(queue <-chan *event.Event) {
+	for ev := range queue {
+		e.lock.Lock()
+		ch := e.ch
+		e.lock.Unlock()
+
+		if ch != nil {
+			ch <- ev
+		}
+	}
+}
+
+func (e *eventPublisher) publish(topic types.Path, data instance_types.LifecycleEvent) {
+	e.lock.Lock()
+	queue := e.queue
+	if queue == nil {
+		e.lock.Unlock()
+		return
+	}
+	e.seq++
+	seq := e.seq
+	e.lock.Unlock()
+
+	ev := event.Event{
+		Topic: topic,
+		Type:  event.Type("Lifecycle"),
+		ID:    fmt.Sprintf("%s-%d", topic.String(), seq),
+	}
+	select {
+	case queue <- ev.Init().Now().WithDataMust(data):
+	default:
+		log.Warnf("Event %s is dropped, the queue of subscribers is full", ev.ID)
+	}
+}
+
+// lifecycle publishes events of a provision or destroy of an instance
+type lifecycle struct {
+	events    *eventPublisher
+	id        instance.ID
+	logicalID string
+	zone      string
+	startedAt time.Time
+}
+
+func (e *eventPublisher) begin(zone string, id instance.ID, logicalID string) *lifecycle {
+	return &lifecycle{events: e, id: id, logicalID: logicalID, zone: zone, startedAt: time.Now()}
+}
+
+func (l *lifecycle) publish(topic types.Path, err error) {
+	if l == nil || l.events == nil {
+		return
+	}
+	data := instance_types.LifecycleEvent{
+		ID:        string(l.id),
+		LogicalID: l.logicalID,
+		Zone:      l.zone,
+		StartedAt: l.startedAt,
+		Elapsed:   time.Since(l.startedAt).String(),
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	l.events.publish(topic, data)
+}
+
+// notify is called on build events, with the server ID once the server is created
+func (l *lifecycle) notify(topic types.Path, serverID int64) {
+	if l == nil {
+		return
+	}
+	if serverID > 0 && l.id == "" {
+		l.id = newInstanceID(l.zone, serverID)
+	}
+	l.publish(topic, nil)
+}
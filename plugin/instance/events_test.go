@@ -0,0 +1,111 @@
+package instance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit/pkg/spi/event"
+	"github.com/docker/infrakit/pkg/types"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTopics(t *testing.T) {
+	e := &eventPublisher{}
+
+	topics, err := e.List(types.PathFromString("."))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"instance"}, topics)
+
+	topics, err = e.List(types.PathFromString("instance/provision"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"booted", "disk-created", "disk-edited", "failed", "server-created", "start"}, topics)
+
+	topics, err = e.List(types.PathFromString("instance/destroy"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deleted", "start", "stopped"}, topics)
+
+	assert.NoError(t, e.Validate(types.PathFromString(".")))
+	assert.NoError(t, e.Validate(types.PathFromString("instance/destroy")))
+	assert.NoError(t, e.Validate(types.PathFromString("instance/provision/booted")))
+	assert.Error(t, e.Validate(types.PathFromString("instance/label")))
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	e := &eventPublisher{}
+
+	// events are dropped until PublishOn is called
+	e.begin("tk1a", "", "etcd-1").publish(topicProvisionStart, nil)
+
+	ch := make(chan *event.Event, 10)
+	e.PublishOn(ch)
+
+	l := e.begin("tk1a", "", "etcd-1")
+	l.notify(topicProvisionDiskCreated, 0)
+	l.notify(topicProvisionServerCreated, 100000000001)
+	l.publish(topicProvisionFailed, errors.New("Booting server is failed"))
+
+	// a nil lifecycle publishes nothing
+	var nop *lifecycle
+	nop.notify(topicProvisionBooted, 100000000001)
+
+	expects := []struct {
+		topic types.Path
+		id    string
+		err   string
+	}{
+		{topicProvisionDiskCreated, "", ""},
+		{topicProvisionServerCreated, "tk1a/100000000001", ""},
+		{topicProvisionFailed, "tk1a/100000000001", "Booting server is failed"},
+	}
+	for _, expect := range expects {
+		ev := receiveEvent(t, ch)
+		assert.Equal(t, expect.topic, ev.Topic)
+		assert.NotEmpty(t, ev.ID)
+
+		data := instance_types.LifecycleEvent{}
+		assert.NoError(t, ev.Data.Decode(&data))
+		assert.Equal(t, expect.id, data.ID)
+		assert.Equal(t, "etcd-1", data.LogicalID)
+		assert.Equal(t, "tk1a", data.Zone)
+		assert.Equal(t, expect.err, data.Error)
+		assert.False(t, data.StartedAt.IsZero())
+		assert.NotEmpty(t, data.Elapsed)
+	}
+}
+
+func receiveEvent(t *testing.T, ch <-chan *event.Event) *event.Event {
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		assert.Fail(t, "no event is received")
+		return &event.Event{}
+	}
+}
+
+func TestPublishWithoutSubscriber(t *testing.T) {
+	e := &eventPublisher{}
+
+	// nobody receives from the channel
+	ch := make(chan *event.Event)
+	e.PublishOn(ch)
+
+	done := make(chan struct{})
+	go func() {
+		l := e.begin("tk1a", "", "etcd-1")
+		for i := 0; i < eventQueueSize*2; i++ {
+			l.notify(topicProvisionStart, 0)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "publishing events is blocked")
+	}
+
+	// queued events are delivered later
+	assert.Equal(t, topicProvisionStart, receiveEvent(t, ch).Topic)
+}
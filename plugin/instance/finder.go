@@ -0,0 +1,144 @@
+package instance
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+const (
+	// v1VersionSearchTerm is a search term matching the Description of servers created by version 1 of the plugin
+	v1VersionSearchTerm = instance_types.InfrakitSakuraCloudVersion + ":"
+
+	// searchPageSize is the number of resources read at once by the search API
+	searchPageSize = 100
+)
+
+// findServers returns all infrakit managed servers having the tags.
+// Only the tags fitting in a single SakuraCloud tag are matched on the API side, so callers must check them again.
+func findServers(client *api.Client, tags map[string]string) ([]sacloud.Server, error) {
+	keys, _ := mergeTags(tags)
+
+	// servers created by current version
+	version, _ := instance_types.EncodeTag(instance_types.InfrakitSakuraCloudVersion, instance_types.InfrakitSakuraCloudCurrentVersion)
+	filterTags := []string{version}
+	for _, k := range keys {
+		if tag, ok := instance_types.EncodeTag(k, tags[k]); ok {
+			filterTags = append(filterTags, tag)
+		}
+	}
+	servers, err := findAllServers(client, func(serverAPI *api.ServerAPI) {
+		serverAPI.WithTags(filterTags)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// servers created by version 1 that keep tags in the Description
+	terms := []string{v1VersionSearchTerm}
+	for _, k := range keys {
+		terms = append(terms, mapToStringSlice(map[string]string{k: tags[k]})...)
+	}
+	v1Servers, err := findAllServers(client, func(serverAPI *api.ServerAPI) {
+		for _, term := range terms {
+			serverAPI.FilterBy("Description", term)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[int64]bool{}
+	for _, s := range servers {
+		found[s.ID] = true
+	}
+	for _, s := range v1Servers {
+		if !found[s.ID] && isV1Server(&s) {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+// findServersPage returns a page of the search results, replaced in tests
+var findServersPage = func(client *api.Client, serverAPI *api.ServerAPI, offset int) (*sacloud.SearchResponse, error) {
+	return serverAPI.Limit(searchPageSize).Offset(offset).Find()
+}
+
+// pageThrough calls fetch with the offset of each page until the total of the search results.
+// fetch returns the number of resources in the page and the total.
+func pageThrough(fetch func(offset int) (int, int, error)) error {
+	for offset := 0; ; {
+		count, total, err := fetch(offset)
+		if err != nil {
+			return err
+		}
+		offset += count
+		if count == 0 || offset >= total {
+			return nil
+		}
+	}
+}
+
+// findAllServers pages through the search results with conditions set by filter
+func findAllServers(client *api.Client, filter func(*api.ServerAPI)) ([]sacloud.Server, error) {
+	servers := []sacloud.Server{}
+	err := pageThrough(func(offset int) (int, int, error) {
+		// use a dedicated API object because search conditions are stored in it
+		serverAPI := api.NewServerAPI(client)
+		filter(serverAPI)
+
+		res, err := findServersPage(client, serverAPI, offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		servers = append(servers, res.Servers...)
+		return len(res.Servers), res.Total, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("found %d servers", len(servers))
+	return servers, nil
+}
+
+// findByLogicalID returns IDs of instances having the logical ID in all managed zones.
+// Instances marked as destroyed are not included.
+func (p *plugin) findByLogicalID(logicalID instance.LogicalID) ([]instance.ID, error) {
+	_, tags := mergeTags(map[string]string{instance_types.InfrakitLogicalID: string(logicalID)}, p.namespace())
+
+	ids := []instance.ID{}
+	for _, zone := range p.managedZones() {
+		descriptions, err := p.describeZone(zone, tags, false, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range descriptions {
+			// describeZone doesn't exclude instances without the tag
+			if d.Tags[instance_types.InfrakitLogicalID] == string(logicalID) {
+				ids = append(ids, d.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// duplicateLogicalIDs returns logical IDs shared by multiple instances, and IDs of those instances
+func duplicateLogicalIDs(descriptions []instance.Description) map[string][]instance.ID {
+	found := map[string][]instance.ID{}
+	for _, d := range descriptions {
+		if logicalID, ok := d.Tags[instance_types.InfrakitLogicalID]; ok && logicalID != "" {
+			found[logicalID] = append(found[logicalID], d.ID)
+		}
+	}
+
+	duplicates := map[string][]instance.ID{}
+	for logicalID, ids := range found {
+		if len(ids) > 1 {
+			duplicates[logicalID] = ids
+		}
+	}
+	return duplicates
+}
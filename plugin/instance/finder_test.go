@@ -0,0 +1,90 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/docker/infrakit/pkg/spi/instance"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateLogicalIDs(t *testing.T) {
+	descriptions := []instance.Description{
+		{ID: "tk1a/1", Tags: map[string]string{instance_types.InfrakitLogicalID: "logical-01"}},
+		{ID: "tk1a/2", Tags: map[string]string{instance_types.InfrakitLogicalID: "logical-02"}},
+		{ID: "is1b/3", Tags: map[string]string{instance_types.InfrakitLogicalID: "logical-01"}},
+		{ID: "tk1a/4", Tags: map[string]string{}},
+		{ID: "tk1a/5", Tags: map[string]string{}},
+	}
+
+	assert.Equal(t, map[string][]instance.ID{
+		"logical-01": {"tk1a/1", "is1b/3"},
+	}, duplicateLogicalIDs(descriptions))
+}
+
+func TestBeginProvisioning(t *testing.T) {
+	p := &plugin{provisioning: map[instance.LogicalID]bool{}}
+
+	assert.NoError(t, p.beginProvisioning("logical-01"))
+	assert.Error(t, p.beginProvisioning("logical-01"))
+	assert.NoError(t, p.beginProvisioning("logical-02"))
+
+	p.endProvisioning("logical-01")
+	assert.NoError(t, p.beginProvisioning("logical-01"))
+}
+
+// stubFindServersPage returns full pages of servers until total, or forever if endless
+func stubFindServersPage(total int, endless bool) (*[]int, func()) {
+	offsets := []int{}
+	orig := findServersPage
+	findServersPage = func(client *api.Client, serverAPI *api.ServerAPI, offset int) (*sacloud.SearchResponse, error) {
+		offsets = append(offsets, offset)
+		count := searchPageSize
+		if !endless && total-offset < count {
+			count = total - offset
+		}
+		servers := []sacloud.Server{}
+		for i := 0; i < count; i++ {
+			servers = append(servers, sacloud.Server{Resource: &sacloud.Resource{ID: int64(100000000000 + offset + i)}})
+		}
+		return &sacloud.SearchResponse{
+			Total:                   total,
+			From:                    offset,
+			Count:                   count,
+			SakuraCloudResourceList: &sacloud.SakuraCloudResourceList{Servers: servers},
+		}, nil
+	}
+	return &offsets, func() { findServersPage = orig }
+}
+
+func TestFindAllServers(t *testing.T) {
+	client := api.NewClient("token", "secret", "tk1a")
+	filtered := 0
+	filter := func(*api.ServerAPI) { filtered++ }
+
+	offsets, restore := stubFindServersPage(250, false)
+	servers, err := findAllServers(client, filter)
+	restore()
+	assert.NoError(t, err)
+	assert.Len(t, servers, 250)
+	assert.Equal(t, int64(100000000249), servers[249].ID)
+	assert.Equal(t, []int{0, 100, 200}, *offsets)
+	assert.Equal(t, 3, filtered)
+
+	// stops at the total even if the API returns more
+	offsets, restore = stubFindServersPage(150, true)
+	servers, err = findAllServers(client, filter)
+	restore()
+	assert.NoError(t, err)
+	assert.Len(t, servers, 200)
+	assert.Equal(t, []int{0, 100}, *offsets)
+
+	offsets, restore = stubFindServersPage(0, false)
+	defer restore()
+	servers, err = findAllServers(client, filter)
+	assert.NoError(t, err)
+	assert.Empty(t, servers)
+	assert.Equal(t, []int{0}, *offsets)
+}
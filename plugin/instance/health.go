@@ -0,0 +1,105 @@
+package instance
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+const (
+	// healthModeNone reports all servers as they are
+	healthModeNone = "none"
+
+	// healthModeFlag reports unhealthy servers with the reason in the infrakit-health tag
+	healthModeFlag = "flag"
+
+	// healthModeExclude leaves unhealthy servers out, so that the group replaces them
+	healthModeExclude = "exclude"
+)
+
+// HealthModes are all of the available modes of HealthPolicy
+var HealthModes = []string{healthModeNone, healthModeFlag, healthModeExclude}
+
+const (
+	healthFailed    = "failed"
+	healthMigrating = "migrating"
+	healthDown      = "down"
+)
+
+// HealthPolicy controls how DescribeInstances treats servers which are failed, stuck in migration or down on their own.
+// Servers marked as destroyed are never checked.
+type HealthPolicy struct {
+	// Mode is one of HealthModes. An empty mode is the same as "none".
+	Mode string
+
+	// GracePeriod is the time after creation while migrating or down servers are regarded as being built
+	GracePeriod time.Duration
+
+	// AutoBootRetries is the number of times to boot a server down on its own before regarding it as unhealthy.
+	// Zero disables auto-boot.
+	AutoBootRetries int
+}
+
+// serverHealth returns the reason why the server is unhealthy, or an empty string if it is healthy
+func serverHealth(server *sacloud.Server, now time.Time, gracePeriod time.Duration) string {
+	if server.IsFailed() {
+		return healthFailed
+	}
+
+	// servers being built are migrating or down for a while
+	if server.CreatedAt != nil && now.Sub(*server.CreatedAt) < gracePeriod {
+		return ""
+	}
+	if server.IsMigrating() {
+		return healthMigrating
+	}
+	if server.IsDown() {
+		return healthDown
+	}
+	return ""
+}
+
+// bootDownServer boots a server down on its own
+var bootDownServer = func(client *api.Client, id int64) error {
+	_, err := client.GetServerAPI().Boot(id)
+	return err
+}
+
+// checkHealth returns the reason why the server is unhealthy, or an empty string if it is healthy.
+// With autoBoot, a server down on its own is booted up to AutoBootRetries times before being regarded as unhealthy.
+// Servers being destroyed are always regarded as healthy, and never booted.
+func (p *plugin) checkHealth(client *api.Client, id instance.ID, server *sacloud.Server, autoBoot bool) string {
+	policy := p.options.HealthPolicy
+	if policy.Mode == "" || policy.Mode == healthModeNone {
+		return ""
+	}
+
+	reason := serverHealth(server, time.Now(), policy.GracePeriod)
+
+	p.lock.Lock()
+	if p.destroying[id] {
+		p.lock.Unlock()
+		return ""
+	}
+	boot := false
+	switch {
+	case reason == "":
+		delete(p.bootAttempts, id)
+	case reason == healthDown && autoBoot && p.bootAttempts[id] < policy.AutoBootRetries:
+		p.bootAttempts[id]++
+		log.Infof("Booting %s down on its own(attempt %d/%d)", id, p.bootAttempts[id], policy.AutoBootRetries)
+		boot = true
+	}
+	p.lock.Unlock()
+
+	if !boot {
+		return reason
+	}
+	if err := bootDownServer(client, server.ID); err != nil {
+		log.Warnf("Booting %s is failed: %s", id, err)
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHealthTestServer(availability sacloud.EAvailability, status string, createdAt time.Time) *sacloud.Server {
+	server := newTestServer(map[string]string{})
+	server.Availability = availability
+	server.CreatedAt = &createdAt
+	server.Instance = &sacloud.Instance{EServerInstanceStatus: &sacloud.EServerInstanceStatus{Status: status}}
+	return server
+}
+
+func TestServerHealth(t *testing.T) {
+	now := time.Date(2017, 1, 1, 1, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour)
+	recent := now.Add(-time.Minute)
+	grace := 10 * time.Minute
+
+	assert.Equal(t, "", serverHealth(newHealthTestServer(sacloud.EAAvailable, "up", old), now, grace))
+	assert.Equal(t, "failed", serverHealth(newHealthTestServer(sacloud.EAFailed, "down", old), now, grace))
+	assert.Equal(t, "migrating", serverHealth(newHealthTestServer(sacloud.EAMigrating, "down", old), now, grace))
+	assert.Equal(t, "down", serverHealth(newHealthTestServer(sacloud.EAAvailable, "down", old), now, grace))
+
+	// servers being built
+	assert.Equal(t, "", serverHealth(newHealthTestServer(sacloud.EAMigrating, "down", recent), now, grace))
+	assert.Equal(t, "", serverHealth(newHealthTestServer(sacloud.EAAvailable, "down", recent), now, grace))
+	assert.Equal(t, "failed", serverHealth(newHealthTestServer(sacloud.EAFailed, "down", recent), now, grace))
+}
+
+func TestCheckHealth(t *testing.T) {
+	id := instance.ID("tk1a/100000000001")
+	down := newHealthTestServer(sacloud.EAAvailable, "down", time.Now().Add(-time.Hour))
+
+	p := &plugin{bootAttempts: map[instance.ID]int{}, destroying: map[instance.ID]bool{}}
+	assert.Equal(t, "", p.checkHealth(nil, id, down, true))
+
+	p.options.HealthPolicy = HealthPolicy{Mode: healthModeNone}
+	assert.Equal(t, "", p.checkHealth(nil, id, down, true))
+
+	// retries are exhausted
+	p.options.HealthPolicy = HealthPolicy{Mode: healthModeFlag, AutoBootRetries: 1}
+	p.bootAttempts[id] = 1
+	assert.Equal(t, "down", p.checkHealth(nil, id, down, true))
+	assert.Equal(t, 1, p.bootAttempts[id])
+
+	// attempts are reset once the server is healthy
+	up := newHealthTestServer(sacloud.EAAvailable, "up", time.Now().Add(-time.Hour))
+	assert.Equal(t, "", p.checkHealth(nil, id, up, true))
+	_, ok := p.bootAttempts[id]
+	assert.False(t, ok)
+}
+
+func stubBootDownServer() (*[]int64, func()) {
+	booted := []int64{}
+	orig := bootDownServer
+	bootDownServer = func(client *api.Client, id int64) error {
+		booted = append(booted, id)
+		return nil
+	}
+	return &booted, func() { bootDownServer = orig }
+}
+
+func TestCheckHealthAutoBoot(t *testing.T) {
+	booted, restore := stubBootDownServer()
+	defer restore()
+
+	id := instance.ID("tk1a/100000000001")
+	down := newHealthTestServer(sacloud.EAAvailable, "down", time.Now().Add(-time.Hour))
+
+	p := &plugin{bootAttempts: map[instance.ID]int{}, destroying: map[instance.ID]bool{}}
+	p.options.HealthPolicy = HealthPolicy{Mode: healthModeFlag, AutoBootRetries: 1}
+
+	// only DescribeInstances boots servers
+	assert.Equal(t, "down", p.checkHealth(nil, id, down, false))
+	assert.Empty(t, *booted)
+	assert.Equal(t, 0, p.bootAttempts[id])
+
+	assert.Equal(t, "", p.checkHealth(nil, id, down, true))
+	assert.Equal(t, []int64{100000000001}, *booted)
+	assert.Equal(t, 1, p.bootAttempts[id])
+}
+
+func TestCheckHealthDestroying(t *testing.T) {
+	booted, restore := stubBootDownServer()
+	defer restore()
+
+	id := instance.ID("tk1a/100000000001")
+	down := newHealthTestServer(sacloud.EAAvailable, "down", time.Now().Add(-time.Hour))
+
+	p := &plugin{bootAttempts: map[instance.ID]int{}, destroying: map[instance.ID]bool{}}
+	p.options.HealthPolicy = HealthPolicy{Mode: healthModeExclude, AutoBootRetries: 1}
+
+	// servers shut down by Destroy are neither booted nor reported as unhealthy
+	p.beginDestroying(id)
+	assert.Equal(t, "", p.checkHealth(nil, id, down, true))
+	assert.Empty(t, *booted)
+	assert.Equal(t, 0, p.bootAttempts[id])
+
+	p.endDestroying(id)
+	assert.Equal(t, "", p.checkHealth(nil, id, down, true))
+	assert.Equal(t, []int64{100000000001}, *booted)
+}
@@ -0,0 +1,159 @@
+package instance
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/docker/infrakit/pkg/spi/metadata"
+	"github.com/docker/infrakit/pkg/types"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+)
+
+const (
+	counterProvisioned = "provisioned"
+	counterDestroyed   = "destroyed"
+	counterFailed      = "failed"
+)
+
+// MetadataPlugin returns the metadata plugin of the instance plugin created by NewSakuraCloudInstancePlugin
+func MetadataPlugin(p instance.Plugin) metadata.Updatable {
+	m, _ := p.(metadata.Updatable)
+	return m
+}
+
+// record counts the result of Provision or Destroy, and keeps the error as lastError
+func (p *plugin) record(counter string, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err != nil {
+		p.counters[counterFailed]++
+		p.lastError = err.Error()
+		return
+	}
+	p.counters[counter]++
+}
+
+// List returns child nodes of the path in the metadata tree
+func (p *plugin) List(path types.Path) ([]string, error) {
+	path = metadataPath(path)
+	tree, err := p.metadataTree(path)
+	if err != nil {
+		return nil, err
+	}
+	return types.List(path, tree), nil
+}
+
+// Get returns the value at the path in the metadata tree
+func (p *plugin) Get(path types.Path) (*types.Any, error) {
+	path = metadataPath(path)
+	tree, err := p.metadataTree(path)
+	if err != nil {
+		return nil, err
+	}
+	return types.GetValue(path, tree)
+}
+
+// metadataPath drops empty and "." components of the path
+func metadataPath(path types.Path) types.Path {
+	cleaned := types.Path{}
+	for _, c := range path {
+		if c != "" && c != "." {
+			cleaned = append(cleaned, c)
+		}
+	}
+	return cleaned
+}
+
+// metadataTree returns the metadata tree below:
+//
+//	zone
+//	namespace/<key>
+//	instances/<zone>/<server ID>/{ip,status,logicalID}
+//	counters/{provisioned,destroyed,failed}
+//	lastError
+//	config/{NamespaceTags,MaxProvisions,DefaultProperties}
+//
+// Instances are described only for paths under instances, to avoid API calls for other nodes.
+func (p *plugin) metadataTree(path types.Path) (map[string]interface{}, error) {
+	p.lock.Lock()
+	config, err := types.AnyValue(p.currentConfig())
+	if err != nil {
+		p.lock.Unlock()
+		return nil, err
+	}
+	tree := map[string]interface{}{
+		"config":    config,
+		"zone":      p.defaultZone,
+		"namespace": copyTags(p.namespaceTags),
+		"instances": map[string]interface{}{},
+		"counters": map[string]interface{}{
+			counterProvisioned: p.counters[counterProvisioned],
+			counterDestroyed:   p.counters[counterDestroyed],
+			counterFailed:      p.counters[counterFailed],
+		},
+		"lastError": p.lastError,
+	}
+	p.lock.Unlock()
+
+	if len(path) > 0 && path[0] == "instances" {
+		instances, err := p.metadataInstances()
+		if err != nil {
+			return nil, err
+		}
+		tree["instances"] = instances
+	}
+	return tree, nil
+}
+
+// metadataInstances returns nodes of all instances in the namespace, keyed by zone and server ID
+func (p *plugin) metadataInstances() (map[string]interface{}, error) {
+	descriptions, err := p.describeInstances(map[string]string{}, true, false)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]interface{}{}
+	for _, d := range descriptions {
+		node := map[string]interface{}{
+			"ip":        "",
+			"status":    "",
+			"logicalID": "",
+		}
+		if d.LogicalID != nil {
+			node["logicalID"] = string(*d.LogicalID)
+		}
+		if d.Properties != nil {
+			desc := instance_types.Description{}
+			if err := d.Properties.Decode(&desc); err != nil {
+				log.Warningln("error decoding instance properties:", err)
+			}
+			node["status"] = desc.Status
+			if len(desc.Networks) > 0 {
+				node["ip"] = desc.Networks[0].IPAddress
+			}
+		}
+
+		// instance IDs are formatted as "zone/server ID"
+		zoneID := strings.SplitN(string(d.ID), "/", 2)
+		if len(zoneID) != 2 {
+			continue
+		}
+		zone, ok := instances[zoneID[0]].(map[string]interface{})
+		if !ok {
+			zone = map[string]interface{}{}
+			instances[zoneID[0]] = zone
+		}
+		zone[zoneID[1]] = node
+	}
+	return instances, nil
+}
+
+func copyTags(tags map[string]string) map[string]interface{} {
+	copied := map[string]interface{}{}
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return copied
+}
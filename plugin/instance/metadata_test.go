@@ -0,0 +1,51 @@
+package instance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/infrakit/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	p := &plugin{
+		defaultZone:   "tk1a",
+		namespaceTags: map[string]string{"cluster": "etcd"},
+		counters:      map[string]int{},
+	}
+	assert.NotNil(t, MetadataPlugin(p))
+
+	p.record(counterProvisioned, nil)
+	p.record(counterProvisioned, nil)
+	p.record(counterDestroyed, nil)
+	p.record(counterProvisioned, errors.New("Creating server is failed"))
+
+	nodes, err := p.List(types.PathFromString("."))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"config", "counters", "instances", "lastError", "namespace", "zone"}, nodes)
+
+	nodes, err = p.List(types.PathFromString("counters"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"destroyed", "failed", "provisioned"}, nodes)
+
+	get := func(path string) interface{} {
+		any, err := p.Get(types.PathFromString(path))
+		assert.NoError(t, err)
+		var v interface{}
+		assert.NoError(t, any.Decode(&v))
+		return v
+	}
+	assert.Equal(t, "tk1a", get("zone"))
+	assert.Equal(t, "etcd", get("namespace/cluster"))
+	assert.Equal(t, float64(2), get("counters/provisioned"))
+	assert.Equal(t, float64(1), get("counters/destroyed"))
+	assert.Equal(t, float64(1), get("counters/failed"))
+	assert.Equal(t, "Creating server is failed", get("lastError"))
+	assert.Nil(t, get("unknown"))
+}
+
+func TestMetadataPath(t *testing.T) {
+	assert.Equal(t, types.Path{}, metadataPath(types.PathFromString(".")))
+	assert.Equal(t, types.Path{"counters", "failed"}, metadataPath(types.Path{"", "counters", ".", "failed"}))
+}
@@ -0,0 +1,124 @@
+package instance
+
+import (
+	"bytes"
+	"fmt"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+func validateServerNetworksParams(sb interface{}, params instance_types.Properties) []error {
+	var errs []error
+	var appendErrors = func(e []error) {
+		errs = append(errs, e...)
+	}
+
+	if _, ok := sb.(serverNetworkParams); !ok {
+		return validateSetProhibited("Networks", params.Networks)
+	}
+
+	// flat parameters are the shorthand for a single NIC
+	if params.NetworkMode != "shared" {
+		appendErrors(validateConflictValues("Networks", params.Networks, map[string]interface{}{"NetworkMode": params.NetworkMode}))
+	}
+	appendErrors(validateConflicts("Networks", params.Networks, map[string]interface{}{
+		"SwitchID":       params.SwitchID,
+		"IPAddress":      params.IPAddress,
+		"NwMasklen":      params.NwMasklen,
+		"DefaultRoute":   params.DefaultRoute,
+		"PacketFilterID": params.PacketFilterID,
+	}))
+
+	if len(params.Networks) > sacloud.ServerMaxInterfaceLen {
+		appendErrors([]error{fmt.Errorf("%q: up to %d NICs can be connected to a server", "Networks", sacloud.ServerMaxInterfaceLen)})
+	}
+
+	_, canEditDisk := sb.(serverEditDiskParam)
+	_, canSetEth0IP := sb.(serverConnectSwitchParamWithEditableDisk)
+
+	for i, nic := range params.Networks {
+		fieldName := fmt.Sprintf("Networks[%d]", i)
+
+		appendErrors(validateRequired(fieldName+".Type", nic.Type))
+		appendErrors(validateInStrValues(fieldName+".Type", nic.Type, "shared", "switch", "disconnect"))
+		appendErrors(validateSakuraID(fieldName+".SwitchID", nic.SwitchID))
+		appendErrors(validateSakuraID(fieldName+".PacketFilterID", nic.PacketFilterID))
+		// addresses are written into the startup script configuring NICs
+		appendErrors(validateIPv4Address(fieldName+".IPAddress", nic.IPAddress))
+		appendErrors(validateIPv4Address(fieldName+".DefaultRoute", nic.DefaultRoute))
+		appendErrors(validateMasklen(fieldName+".NwMasklen", nic.NwMasklen))
+
+		switch nic.Type {
+		case "shared", "disconnect":
+			if nic.Type == "shared" && i > 0 {
+				appendErrors([]error{fmt.Errorf("%q: only eth0 can be connected to the shared segment", fieldName+".Type")})
+			}
+			appendErrors(validateConflicts(fieldName+".Type", nic.Type, map[string]interface{}{
+				fieldName + ".SwitchID":     nic.SwitchID,
+				fieldName + ".IPAddress":    nic.IPAddress,
+				fieldName + ".NwMasklen":    nic.NwMasklen,
+				fieldName + ".DefaultRoute": nic.DefaultRoute,
+			}))
+		case "switch":
+			appendErrors(validateRequired(fieldName+".SwitchID", nic.SwitchID))
+
+			// eth0 is configured with the disk edit, and other NICs are configured with a startup script
+			if (i == 0 && !canSetEth0IP) || (i > 0 && !canEditDisk) {
+				appendErrors(validateSetProhibited(fieldName+".IPAddress", nic.IPAddress))
+				appendErrors(validateSetProhibited(fieldName+".NwMasklen", nic.NwMasklen))
+				appendErrors(validateSetProhibited(fieldName+".DefaultRoute", nic.DefaultRoute))
+			} else if i > 0 && nic.IPAddress != "" {
+				appendErrors(validateRequired(fieldName+".NwMasklen", nic.NwMasklen))
+			}
+		}
+	}
+
+	return errs
+}
+
+var networkStartupScriptTemplate = `#!/bin/sh
+# @sacloud-once
+# @sacloud-desc network configuration by infrakit-instance-sakuracloud
+configure_nic() {
+  dev=$1; ip=$2; masklen=$3; gw=$4
+  if [ -d /etc/sysconfig/network-scripts ]; then
+    cat > /etc/sysconfig/network-scripts/ifcfg-$dev <<EOF
+DEVICE=$dev
+BOOTPROTO=static
+ONBOOT=yes
+IPADDR=$ip
+PREFIX=$masklen
+EOF
+    [ -n "$gw" ] && echo "GATEWAY=$gw" >> /etc/sysconfig/network-scripts/ifcfg-$dev
+  elif [ -f /etc/network/interfaces ]; then
+    cat >> /etc/network/interfaces <<EOF
+
+auto $dev
+iface $dev inet static
+  address $ip/$masklen
+EOF
+    [ -n "$gw" ] && echo "  gateway $gw" >> /etc/network/interfaces
+  fi
+  ip link set $dev up
+  ip addr add $ip/$masklen dev $dev
+  [ -n "$gw" ] && ip route replace default via $gw dev $dev
+}
+%s
+exit 0`
+
+// networkStartupScript returns a startup script configuring IP addresses of NICs other than eth0.
+// An empty string is returned if there is nothing to configure.
+func networkStartupScript(nics []instance_types.Network) string {
+	buf := bytes.NewBufferString("")
+	for i, nic := range nics {
+		if i == 0 || nic.Type != "switch" || nic.IPAddress == "" {
+			continue
+		}
+		fmt.Fprintf(buf, "configure_nic eth%d %s %d %q\n", i, nic.IPAddress, nic.NwMasklen, nic.DefaultRoute)
+	}
+	if buf.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf(networkStartupScriptTemplate, buf.String())
+}
@@ -0,0 +1,70 @@
+package instance
+
+import (
+	"strings"
+	"testing"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/builder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateServerNetworksParams(t *testing.T) {
+	client := api.NewClient("token", "secret", "tk1a")
+	params := instance_types.Properties{
+		NetworkMode: "shared",
+		Networks: []instance_types.Network{
+			{Type: "switch", SwitchID: 112233445566, IPAddress: "192.168.0.11", NwMasklen: 24, DefaultRoute: "192.168.0.1"},
+			{Type: "switch", SwitchID: 112233445567, IPAddress: "192.168.1.11", NwMasklen: 24},
+			{Type: "disconnect"},
+		},
+	}
+
+	sb := builder.ServerFromArchive(client, "test", 112233445568)
+	assert.Empty(t, validateServerNetworksParams(sb, params))
+
+	// IP addresses can't be set without disk edit
+	sb2 := builder.ServerFromExistsDisk(client, "test", 112233445568)
+	assert.Len(t, validateServerNetworksParams(sb2, params), 5)
+
+	// shared segment is available only for eth0
+	params.Networks = []instance_types.Network{{Type: "disconnect"}, {Type: "shared"}}
+	assert.Len(t, validateServerNetworksParams(sb, params), 1)
+
+	// addresses must be IPv4
+	params.Networks = []instance_types.Network{
+		{Type: "switch", SwitchID: 112233445566, IPAddress: "192.168.0.11", NwMasklen: 24},
+		{Type: "switch", SwitchID: 112233445567, IPAddress: "192.168.1.11; rm -rf /", NwMasklen: 33, DefaultRoute: "fe80::1"},
+	}
+	errs := validateServerNetworksParams(sb, params)
+	assert.Len(t, errs, 3)
+	assert.EqualError(t, errs[0], `"Networks[1].IPAddress": must be an IPv4 address`)
+	assert.EqualError(t, errs[1], `"Networks[1].DefaultRoute": must be an IPv4 address`)
+	assert.EqualError(t, errs[2], `"Networks[1].NwMasklen": must be between 1 and 32`)
+
+	params.Networks[1] = instance_types.Network{Type: "switch", SwitchID: 112233445567, IPAddress: "::ffff:192.168.1.11", NwMasklen: 24}
+	assert.Len(t, validateServerNetworksParams(sb, params), 1)
+
+	// conflicts with the shorthand
+	params.Networks = []instance_types.Network{{Type: "disconnect"}, {Type: "shared"}}
+	params.SwitchID = 112233445566
+	assert.Len(t, validateServerNetworksParams(sb, params), 2)
+}
+
+func TestNetworkStartupScript(t *testing.T) {
+	assert.Empty(t, networkStartupScript([]instance_types.Network{
+		{Type: "switch", SwitchID: 112233445566, IPAddress: "192.168.0.11", NwMasklen: 24},
+	}))
+
+	script := networkStartupScript([]instance_types.Network{
+		{Type: "shared"},
+		{Type: "switch", SwitchID: 112233445566, IPAddress: "192.168.0.11", NwMasklen: 24},
+		{Type: "disconnect"},
+		{Type: "switch", SwitchID: 112233445567, IPAddress: "192.168.1.11", NwMasklen: 28, DefaultRoute: "192.168.1.1"},
+	})
+	assert.True(t, strings.HasPrefix(script, "#!/bin/sh\n# @sacloud-once"))
+	assert.Contains(t, script, "configure_nic eth1 192.168.0.11 24 \"\"\n")
+	assert.Contains(t, script, "configure_nic eth3 192.168.1.11 28 \"192.168.1.1\"\n")
+	assert.NotContains(t, script, "eth2")
+}
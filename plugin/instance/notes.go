@@ -0,0 +1,135 @@
+package instance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/infrakit/pkg/template"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+// noteHashLength is the length of the content hash in the tag of notes, short enough to fit in a SakuraCloud tag
+const noteHashLength = 16
+
+// noteLock serializes finding and creating notes, so that notes with the same content are not created concurrently
+var noteLock sync.Mutex
+
+// fetchStartupScript reads the content of a local path, file:// or http(s) URL
+var fetchStartupScript = func(location string) (string, error) {
+	if !strings.Contains(location, "://") {
+		path, err := filepath.Abs(location)
+		if err != nil {
+			return "", err
+		}
+		location = "file://" + path
+	}
+	b, err := template.Fetch(location, template.Options{})
+	return string(b), err
+}
+
+// findNotesPage returns a page of the search results, replaced in tests
+var findNotesPage = func(client *api.Client, noteAPI *api.NoteAPI, offset int) (*sacloud.SearchResponse, error) {
+	return noteAPI.Limit(searchPageSize).Offset(offset).Find()
+}
+
+// findNotes returns notes having the tag in the zone of the client
+var findNotes = func(client *api.Client, tag string) ([]sacloud.Note, error) {
+	notes := []sacloud.Note{}
+	err := pageThrough(func(offset int) (int, int, error) {
+		// use a dedicated API object because search conditions are stored in it
+		res, err := findNotesPage(client, api.NewNoteAPI(client).WithTag(tag), offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		notes = append(notes, res.Notes...)
+		return len(res.Notes), res.Total, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+var createNote = func(client *api.Client, note *sacloud.Note) (*sacloud.Note, error) {
+	return client.GetNoteAPI().Create(note)
+}
+
+// loadStartupScriptFiles returns the Properties with contents of StartupScriptFiles appended to StartupScripts
+func loadStartupScriptFiles(params instance_types.Properties) (instance_types.Properties, error) {
+	scripts := append([]string{}, params.StartupScripts...)
+	for i, location := range params.StartupScriptFiles {
+		script, err := fetchStartupScript(location)
+		if err != nil {
+			return params, fmt.Errorf("%q: reading %s is failed: %s", fmt.Sprintf("StartupScriptFiles[%d]", i), location, err)
+		}
+		scripts = append(scripts, script)
+	}
+	params.StartupScripts = scripts
+	params.StartupScriptFiles = nil
+	return params, nil
+}
+
+func noteHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:noteHashLength]
+}
+
+// findOrCreateNote returns the ID of the note having the content, created by the plugin before.
+// If there is no such note, a new note tagged with the hash of the content is created.
+func findOrCreateNote(client *api.Client, namePrefix string, content string) (int64, error) {
+	noteLock.Lock()
+	defer noteLock.Unlock()
+
+	hash := noteHash(content)
+	tag, _ := instance_types.EncodeTag(instance_types.InfrakitStartupScriptHash, hash)
+
+	notes, err := findNotes(client, tag)
+	if err != nil {
+		return 0, fmt.Errorf("Finding startup scripts is failed: %s", err)
+	}
+	for _, n := range notes {
+		// the hash is shortened, compare the content too
+		if n.HasTag(tag) && n.Content == content {
+			return n.ID, nil
+		}
+	}
+
+	note := client.GetNoteAPI().New()
+	note.Name = fmt.Sprintf("%s-%s", namePrefix, hash)
+	note.Description = "created by infrakit-instance-sakuracloud"
+	note.Content = content
+	note.Tags = []string{tag}
+
+	created, err := createNote(client, note)
+	if err != nil {
+		return 0, fmt.Errorf("Creating startup script is failed: %s", err)
+	}
+	return created.ID, nil
+}
+
+// persistSharedScripts replaces StartupScripts not changed by rendering, which are the same for all instances, with IDs of persistent notes.
+// Scripts rendered for the instance are left in StartupScripts, and created as ephemeral notes so that no note is left for each instance.
+func persistSharedScripts(client *api.Client, params instance_types.Properties, unrendered []string) (instance_types.Properties, error) {
+	scripts := []string{}
+	ids := append([]int64{}, params.StartupScriptIDs...)
+	for i, script := range params.StartupScripts {
+		if i >= len(unrendered) || script != unrendered[i] {
+			scripts = append(scripts, script)
+			continue
+		}
+		id, err := findOrCreateNote(client, params.NamePrefix, script)
+		if err != nil {
+			return params, err
+		}
+		ids = append(ids, id)
+	}
+	params.StartupScripts = scripts
+	params.StartupScriptIDs = ids
+	return params, nil
+}
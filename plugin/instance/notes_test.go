@@ -0,0 +1,144 @@
+package instance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadStartupScriptFiles(t *testing.T) {
+	file, err := ioutil.TempFile("", "startup-script")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("echo {{ .Name }}")
+	file.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "echo from url")
+	}))
+	defer server.Close()
+
+	params := instance_types.Properties{
+		StartupScripts:     []string{"echo inline"},
+		StartupScriptFiles: []string{file.Name(), "file://" + file.Name(), server.URL + "/init.sh"},
+	}
+	loaded, err := loadStartupScriptFiles(params)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"echo inline", "echo {{ .Name }}", "echo {{ .Name }}", "echo from url"}, loaded.StartupScripts)
+	assert.Empty(t, loaded.StartupScriptFiles)
+	assert.Equal(t, []string{"echo inline"}, params.StartupScripts)
+
+	params.StartupScriptFiles = []string{file.Name() + ".notfound"}
+	_, err = loadStartupScriptFiles(params)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"StartupScriptFiles[0]"`)
+}
+
+// stubNotes stubs finding and creating notes, and returns the created notes
+func stubNotes() (*[]sacloud.Note, func()) {
+	originalFind, originalCreate := findNotes, createNote
+
+	notes := []sacloud.Note{}
+	findNotes = func(client *api.Client, tag string) ([]sacloud.Note, error) {
+		found := []sacloud.Note{}
+		for _, n := range notes {
+			if n.HasTag(tag) {
+				found = append(found, n)
+			}
+		}
+		return found, nil
+	}
+	createNote = func(client *api.Client, note *sacloud.Note) (*sacloud.Note, error) {
+		note.Resource = &sacloud.Resource{ID: int64(400000000001 + len(notes))}
+		notes = append(notes, *note)
+		return note, nil
+	}
+	return &notes, func() { findNotes, createNote = originalFind, originalCreate }
+}
+
+func TestFindOrCreateNote(t *testing.T) {
+	stubbed, restore := stubNotes()
+	defer restore()
+
+	client := api.NewClient("token", "secret", "tk1a")
+
+	id, err := findOrCreateNote(client, "web", "echo hello")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400000000001), id)
+	assert.Len(t, *stubbed, 1)
+
+	tag, _ := instance_types.EncodeTag(instance_types.InfrakitStartupScriptHash, noteHash("echo hello"))
+	assert.Equal(t, []string{tag}, (*stubbed)[0].Tags)
+	assert.True(t, len(tag) <= instance_types.MaxTagLength)
+
+	// reused
+	id, err = findOrCreateNote(client, "web", "echo hello")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400000000001), id)
+	assert.Len(t, *stubbed, 1)
+
+	id, err = findOrCreateNote(client, "web", "echo world")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(400000000002), id)
+}
+
+func TestFindNotes(t *testing.T) {
+	original := findNotesPage
+	defer func() { findNotesPage = original }()
+
+	offsets := []int{}
+	findNotesPage = func(client *api.Client, noteAPI *api.NoteAPI, offset int) (*sacloud.SearchResponse, error) {
+		offsets = append(offsets, offset)
+		notes := []sacloud.Note{}
+		for i := offset; i < 120 && i < offset+searchPageSize; i++ {
+			notes = append(notes, sacloud.Note{Resource: &sacloud.Resource{ID: int64(400000000001 + i)}})
+		}
+		return &sacloud.SearchResponse{
+			Total:                   120,
+			From:                    offset,
+			Count:                   len(notes),
+			SakuraCloudResourceList: &sacloud.SakuraCloudResourceList{Notes: notes},
+		}, nil
+	}
+
+	notes, err := findNotes(api.NewClient("token", "secret", "tk1a"), "ik:.nh=0123456789abcdef")
+	assert.NoError(t, err)
+	assert.Len(t, notes, 120)
+	assert.Equal(t, []int{0, 100}, offsets)
+}
+
+func TestPersistSharedScripts(t *testing.T) {
+	stubbed, restore := stubNotes()
+	defer restore()
+
+	client := api.NewClient("token", "secret", "tk1a")
+	unrendered := []string{"echo shared", "echo {{ .Name }}"}
+	params := instance_types.Properties{
+		NamePrefix:       "web",
+		StartupScripts:   []string{"echo shared", "echo web-abcdef", "#!/bin/sh\n# init"},
+		StartupScriptIDs: []int64{400000000099},
+	}
+
+	// only the script not changed by rendering is kept as a persistent note
+	persisted, err := persistSharedScripts(client, params, unrendered)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{400000000099, 400000000001}, persisted.StartupScriptIDs)
+	assert.Equal(t, []string{"echo web-abcdef", "#!/bin/sh\n# init"}, persisted.StartupScripts)
+	assert.Len(t, *stubbed, 1)
+	assert.Equal(t, "echo shared", (*stubbed)[0].Content)
+
+	// shared between instances
+	params.StartupScripts = []string{"echo shared", "echo web-123456"}
+	persisted, err = persistSharedScripts(client, params, unrendered)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{400000000099, 400000000001}, persisted.StartupScriptIDs)
+	assert.Len(t, *stubbed, 1)
+}
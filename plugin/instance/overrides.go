@@ -0,0 +1,99 @@
+package instance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/infrakit/pkg/types"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+)
+
+// uniqueOverrideFields are fields which must not share the same value between logical IDs when overridden
+var uniqueOverrideFields = []string{"IPAddress", "DiskID", "Hostname"}
+
+// validateLogicalIDOverrides validates Properties of each logical ID in LogicalIDOverrides,
+// and checks that overridden values of uniqueOverrideFields don't conflict between logical IDs.
+func (p *plugin) validateLogicalIDOverrides(req *types.Any, properties instance_types.Properties) error {
+	logicalIDs := []string{}
+	for logicalID := range properties.LogicalIDOverrides {
+		logicalIDs = append(logicalIDs, logicalID)
+	}
+	sort.Strings(logicalIDs)
+
+	errs := []error{}
+	owners := map[string]map[string]string{}
+	for _, field := range uniqueOverrideFields {
+		owners[field] = map[string]string{}
+	}
+
+	for _, logicalID := range logicalIDs {
+		fieldName := fmt.Sprintf("LogicalIDOverrides[%s]", logicalID)
+
+		override := map[string]interface{}{}
+		if any := properties.LogicalIDOverrides[logicalID]; any != nil {
+			if err := any.Decode(&override); err != nil {
+				errs = append(errs, fmt.Errorf("%q: %s", fieldName, err))
+				continue
+			}
+		}
+		if hasField(override, "LogicalIDOverrides") {
+			errs = append(errs, fmt.Errorf("%q: LogicalIDOverrides can't be nested", fieldName))
+			continue
+		}
+
+		overridden, err := instance_types.ParsePropertiesFor(req, logicalID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %s", fieldName, err))
+			continue
+		}
+
+		values := map[string]interface{}{
+			"IPAddress": overridden.IPAddress,
+			"DiskID":    overridden.DiskID,
+			"Hostname":  overridden.Hostname,
+		}
+		for _, field := range uniqueOverrideFields {
+			if !hasField(override, field) || isEmpty(values[field]) {
+				continue
+			}
+			value := fmt.Sprintf("%v", values[field])
+			if owner, ok := owners[field][value]; ok {
+				errs = append(errs, fmt.Errorf("%q: %s(%s) is conflict with logical ID %s", fieldName, field, value, owner))
+				continue
+			}
+			owners[field][value] = logicalID
+		}
+
+		zone := p.defaultZone
+		if len(overridden.Zones) > 0 {
+			zone = overridden.Zones[0]
+		}
+		if e := validateArchiveSelector(overridden); len(e) > 0 {
+			errs = append(errs, fmt.Errorf("%q: %s", fieldName, flattenErrors(e)))
+			continue
+		}
+		if err := p.validateInZones(overridden); err != nil {
+			errs = append(errs, fmt.Errorf("%q: %s", fieldName, err))
+			continue
+		}
+		if err := p.validatePlans(overridden); err != nil {
+			errs = append(errs, fmt.Errorf("%q: %s", fieldName, err))
+		}
+		if err := validateStartupScripts(zone, logicalID, overridden); err != nil {
+			errs = append(errs, fmt.Errorf("%q: %s", fieldName, err))
+		}
+	}
+
+	return flattenErrors(errs)
+}
+
+// hasField returns true if the decoded JSON object has the field(case-insensitive, same as encoding/json)
+func hasField(object map[string]interface{}, field string) bool {
+	for k := range object {
+		if strings.EqualFold(k, field) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/docker/infrakit/pkg/types"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLogicalIDOverrides(t *testing.T) {
+	_, restore := stubResourceReaders(112233445566, 112233445567)
+	defer restore()
+
+	p := NewSakuraCloudInstancePlugin(api.NewClient("token", "secret", "tk1a"), map[string]string{}, Options{}).(*plugin)
+	p.catalogs["tk1a"] = testCatalog()
+
+	validate := func(req *types.Any) error {
+		properties, err := instance_types.ParseProperties(req)
+		assert.NoError(t, err)
+		return p.validateLogicalIDOverrides(req, properties)
+	}
+
+	err := validate(types.AnyString(`{
+	  "NamePrefix": "etcd",
+	  "SourceArchiveID": 112233445567,
+	  "Password": "password",
+	  "NetworkMode": "switch",
+	  "SwitchID": 112233445566,
+	  "IPAddress": "192.168.0.10",
+	  "NwMasklen": 24,
+	  "Hostname": "etcd",
+	  "LogicalIDOverrides": {
+	    "etcd-1": {"IPAddress": "192.168.0.11"},
+	    "etcd-2": {"IPAddress": "192.168.0.12", "Memory": 4}
+	  }
+	}`))
+	assert.NoError(t, err)
+
+	err = validate(types.AnyString(`{
+	  "NamePrefix": "etcd",
+	  "SourceArchiveID": 112233445567,
+	  "Password": "password",
+	  "NetworkMode": "switch",
+	  "SwitchID": 112233445566,
+	  "NwMasklen": 24,
+	  "LogicalIDOverrides": {
+	    "etcd-1": {"IPAddress": "192.168.0.11", "Hostname": "etcd"},
+	    "etcd-2": {"IPAddress": "192.168.0.11", "Hostname": "etcd"}
+	  }
+	}`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "IPAddress(192.168.0.11) is conflict with logical ID etcd-1")
+	assert.Contains(t, err.Error(), "Hostname(etcd) is conflict with logical ID etcd-1")
+
+	err = validate(types.AnyString(`{
+	  "NamePrefix": "etcd",
+	  "LogicalIDOverrides": {
+	    "etcd-1": {"LogicalIDOverrides": {}}
+	  }
+	}`))
+	assert.Error(t, err)
+
+	err = validate(types.AnyString(`{
+	  "NamePrefix": "etcd",
+	  "SourceArchiveID": 112233445567,
+	  "Password": "password",
+	  "LogicalIDOverrides": {
+	    "etcd-1": {"Memory": 3}
+	  }
+	}`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "server plan(Core: 1, Memory: 3GB) is not available in zone tk1a")
+}
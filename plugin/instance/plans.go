@@ -0,0 +1,204 @@
+package instance
+
+import (
+	"fmt"
+	"sort"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+// serverPlan is a pair of core count and memory size(GB) available in a zone
+type serverPlan struct {
+	core   int
+	memory int
+}
+
+// productCatalog is server and disk plans available in a zone
+type productCatalog struct {
+	// serverPlans are sorted by core count and memory size
+	serverPlans []serverPlan
+
+	// diskSizes are sorted sizes(GB) for each disk plan name
+	diskSizes map[string][]int
+}
+
+var diskPlanIDs = map[string]sacloud.DiskPlanID{
+	"ssd": sacloud.DiskPlanSSDID,
+	"hdd": sacloud.DiskPlanHDDID,
+}
+
+// fetchProductCatalog reads available plans with ProductServerAPI and ProductDiskAPI
+var fetchProductCatalog = func(client *api.Client) (*productCatalog, error) {
+	catalog := &productCatalog{diskSizes: map[string][]int{}}
+
+	servers, err := client.GetProductServerAPI().Reset().Limit(1000).Find()
+	if err != nil {
+		return nil, fmt.Errorf("Reading server plans is failed: %s", err)
+	}
+	for _, plan := range servers.ServerPlans {
+		if plan.IsAvailable() {
+			catalog.serverPlans = append(catalog.serverPlans, serverPlan{core: plan.GetCPU(), memory: plan.GetMemoryGB()})
+		}
+	}
+	sort.Slice(catalog.serverPlans, func(i, j int) bool {
+		a, b := catalog.serverPlans[i], catalog.serverPlans[j]
+		return a.core < b.core || (a.core == b.core && a.memory < b.memory)
+	})
+
+	disks, err := client.GetProductDiskAPI().Reset().Limit(1000).Find()
+	if err != nil {
+		return nil, fmt.Errorf("Reading disk plans is failed: %s", err)
+	}
+	for _, plan := range disks.DiskPlans {
+		if !plan.IsAvailable() || plan.Resource == nil {
+			continue
+		}
+		for name, id := range diskPlanIDs {
+			if plan.ID != int64(id) {
+				continue
+			}
+			sizes := []int{}
+			for _, size := range plan.Size {
+				if size.IsAvailable() {
+					sizes = append(sizes, size.GetSizeGB())
+				}
+			}
+			sort.Ints(sizes)
+			catalog.diskSizes[name] = sizes
+		}
+	}
+
+	return catalog, nil
+}
+
+// catalogFor returns the product catalog of the zone, which is read only once per zone
+func (p *plugin) catalogFor(zone string) (*productCatalog, error) {
+	p.lock.Lock()
+	catalog, ok := p.catalogs[zone]
+	p.lock.Unlock()
+	if ok {
+		return catalog, nil
+	}
+
+	client, err := p.clientFor(zone)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err = fetchProductCatalog(client)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	p.catalogs[zone] = catalog
+	p.lock.Unlock()
+	return catalog, nil
+}
+
+// validatePlans checks that server and disk plans of the Properties are available in all zones of the Properties
+func (p *plugin) validatePlans(params instance_types.Properties) error {
+	zones := params.Zones
+	if len(zones) == 0 {
+		zones = []string{p.defaultZone}
+	}
+
+	errs := []error{}
+	for _, zone := range zones {
+		catalog, err := p.catalogFor(zone)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, catalog.validate(zone, params)...)
+	}
+	return flattenErrors(errs)
+}
+
+func (c *productCatalog) validate(zone string, params instance_types.Properties) []error {
+	errs := []error{}
+
+	if !c.hasServerPlan(params.Core, params.Memory) {
+		err := fmt.Errorf("%q: server plan(Core: %d, Memory: %dGB) is not available in zone %s", "Core/Memory", params.Core, params.Memory, zone)
+		if nearest, ok := c.nearestServerPlan(params.Core, params.Memory); ok {
+			err = fmt.Errorf("%s, the nearest plan is Core: %d, Memory: %dGB", err, nearest.core, nearest.memory)
+		}
+		errs = append(errs, err)
+	}
+
+	if params.DiskMode == "create" {
+		errs = append(errs, c.validateDisk(zone, "DiskPlan", "DiskSize", params.DiskPlan, params.DiskSize)...)
+	}
+	for i, d := range params.AdditionalDisks {
+		fieldName := fmt.Sprintf("AdditionalDisks[%d]", i)
+		errs = append(errs, c.validateDisk(zone, fieldName+".Plan", fieldName+".Size", d.Plan, d.Size)...)
+	}
+	return errs
+}
+
+func (c *productCatalog) validateDisk(zone, planField, sizeField, plan string, size int) []error {
+	sizes, ok := c.diskSizes[plan]
+	if !ok {
+		available := []string{}
+		for name := range c.diskSizes {
+			available = append(available, name)
+		}
+		sort.Strings(available)
+		return []error{fmt.Errorf("%q: disk plan %s is not available in zone %s, available plans are %v", planField, plan, zone, available)}
+	}
+
+	for _, s := range sizes {
+		if s == size {
+			return nil
+		}
+	}
+	err := fmt.Errorf("%q: %dGB %s disk is not available in zone %s", sizeField, size, plan, zone)
+	if nearest, ok := nearestSize(sizes, size); ok {
+		err = fmt.Errorf("%s, the nearest size is %dGB", err, nearest)
+	}
+	return []error{err}
+}
+
+func (c *productCatalog) hasServerPlan(core, memory int) bool {
+	for _, plan := range c.serverPlans {
+		if plan.core == core && plan.memory == memory {
+			return true
+		}
+	}
+	return false
+}
+
+// nearestServerPlan returns the plan with the smallest difference in core count and memory size.
+// Larger plans are preferred on ties, so that the suggestion meets the requirement.
+func (c *productCatalog) nearestServerPlan(core, memory int) (serverPlan, bool) {
+	var nearest serverPlan
+	found := false
+	best := 0
+	for _, plan := range c.serverPlans {
+		d := abs(plan.core-core) + abs(plan.memory-memory)
+		if !found || d < best || (d == best && plan.core+plan.memory > nearest.core+nearest.memory) {
+			nearest, best, found = plan, d, true
+		}
+	}
+	return nearest, found
+}
+
+// nearestSize returns the size nearest to given size. Larger sizes are preferred on ties.
+func nearestSize(sizes []int, size int) (int, bool) {
+	nearest := 0
+	found := false
+	for _, s := range sizes {
+		if !found || abs(s-size) < abs(nearest-size) || (abs(s-size) == abs(nearest-size) && s > nearest) {
+			nearest, found = s, true
+		}
+	}
+	return nearest, found
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,72 @@
+package instance
+
+import (
+	"testing"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCatalog() *productCatalog {
+	return &productCatalog{
+		serverPlans: []serverPlan{{1, 1}, {1, 2}, {1, 4}, {2, 2}, {2, 4}, {4, 4}, {4, 8}},
+		diskSizes: map[string][]int{
+			"ssd": {20, 40, 100},
+			"hdd": {40, 60, 80},
+		},
+	}
+}
+
+func TestValidatePlans(t *testing.T) {
+	catalog := testCatalog()
+	params := instance_types.Properties{
+		Core:     1,
+		Memory:   1,
+		DiskMode: "create",
+		DiskPlan: "ssd",
+		DiskSize: 20,
+	}
+	assert.Empty(t, catalog.validate("tk1a", params))
+
+	params.Core = 3
+	params.Memory = 5
+	params.DiskSize = 30
+	params.AdditionalDisks = []instance_types.AdditionalDisk{{Plan: "hdd", Size: 100}}
+	errs := catalog.validate("tk1a", params)
+	assert.Len(t, errs, 3)
+	assert.EqualError(t, errs[0], `"Core/Memory": server plan(Core: 3, Memory: 5GB) is not available in zone tk1a, the nearest plan is Core: 4, Memory: 4GB`)
+	assert.EqualError(t, errs[1], `"DiskSize": 30GB ssd disk is not available in zone tk1a, the nearest size is 40GB`)
+	assert.EqualError(t, errs[2], `"AdditionalDisks[0].Size": 100GB hdd disk is not available in zone tk1a, the nearest size is 80GB`)
+
+	// the boot disk isn't created
+	params = instance_types.Properties{Core: 1, Memory: 1, DiskMode: "connect", DiskSize: 30}
+	assert.Empty(t, catalog.validate("tk1a", params))
+
+	catalog.diskSizes = map[string][]int{"hdd": {40}}
+	params = instance_types.Properties{Core: 1, Memory: 1, DiskMode: "create", DiskPlan: "ssd", DiskSize: 20}
+	errs = catalog.validate("tk1a", params)
+	assert.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], `"DiskPlan": disk plan ssd is not available in zone tk1a, available plans are [hdd]`)
+}
+
+func TestCatalogFor(t *testing.T) {
+	original := fetchProductCatalog
+	defer func() { fetchProductCatalog = original }()
+
+	fetched := map[string]int{}
+	fetchProductCatalog = func(client *api.Client) (*productCatalog, error) {
+		fetched[client.Zone]++
+		return testCatalog(), nil
+	}
+
+	p := NewSakuraCloudInstancePlugin(api.NewClient("token", "secret", "tk1a"), map[string]string{}, Options{Zones: []string{"is1b"}}).(*plugin)
+
+	params := instance_types.Properties{Zones: []string{"tk1a", "is1b"}, Core: 4, Memory: 8, DiskMode: "create", DiskPlan: "ssd", DiskSize: 100}
+	assert.NoError(t, p.validatePlans(params))
+	assert.NoError(t, p.validatePlans(params))
+	assert.Equal(t, map[string]int{"tk1a": 1, "is1b": 1}, fetched)
+
+	params.Zones = []string{"is1a"}
+	assert.Error(t, p.validatePlans(params))
+}
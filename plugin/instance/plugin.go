@@ -9,10 +9,11 @@ import (
 	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
 	"github.com/sacloud/infrakit.sakuracloud/version"
 	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/builder"
 	"math/rand"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,17 +29,83 @@ type builderAPI interface {
 }
 
 type plugin struct {
-	client        *api.Client
+	clients       map[string]*api.Client
+	defaultZone   string
 	namespaceTags map[string]string
+	options       Options
+
+	nextZone     int
+	provisioning map[instance.LogicalID]bool
+	destroying   map[instance.ID]bool
+	catalogs     map[string]*productCatalog
+	bootAttempts map[instance.ID]int
+	counters     map[string]int
+	lastError    string
+
+	maxProvisions     int
+	provisions        int
+	provisionDone     *sync.Cond
+	defaultProperties *types.Any
+
+	events *eventPublisher
+
+	lock sync.Mutex
+}
+
+// Options is the plugin-wide configuration
+type Options struct {
+	// Zones are additional zones to be managed by the plugin
+	Zones []string
+
+	// ShutdownPolicy controls how servers are shut down on Destroy
+	ShutdownPolicy ShutdownPolicy
+
+	// KeyDir is the directory to store private keys generated with GenerateSSHKey
+	KeyDir string
+
+	// HealthPolicy controls how DescribeInstances treats unhealthy servers
+	HealthPolicy HealthPolicy
+
+	// MaxProvisions is the initial Config.MaxProvisions
+	MaxProvisions int
+
+	// DefaultProperties is the initial Config.DefaultProperties
+	DefaultProperties *types.Any
 }
 
-// NewSakuraCloudInstancePlugin creates a new SakuraCloud instance plugin
-func NewSakuraCloudInstancePlugin(client *api.Client, namespace map[string]string) instance.Plugin {
+// NewSakuraCloudInstancePlugin creates a new SakuraCloud instance plugin.
+// The zone of client is the default zone, and options.Zones are additional zones to be managed by the plugin.
+func NewSakuraCloudInstancePlugin(client *api.Client, namespace map[string]string, options Options) instance.Plugin {
 
-	return &plugin{
-		client:        client,
+	clients := map[string]*api.Client{
+		client.Zone: client,
+	}
+	for _, zone := range options.Zones {
+		if _, exists := clients[zone]; exists {
+			continue
+		}
+		c := client.Clone()
+		c.Zone = zone
+		clients[zone] = c
+	}
+
+	p := &plugin{
+		clients:       clients,
+		defaultZone:   client.Zone,
 		namespaceTags: namespace,
+		options:       options,
+		provisioning:  map[instance.LogicalID]bool{},
+		destroying:    map[instance.ID]bool{},
+		catalogs:      map[string]*productCatalog{},
+		bootAttempts:  map[instance.ID]int{},
+		counters:      map[string]int{},
+
+		maxProvisions:     options.MaxProvisions,
+		defaultProperties: options.DefaultProperties,
+		events:            &eventPublisher{},
 	}
+	p.provisionDone = sync.NewCond(&p.lock)
+	return p
 }
 
 // Info returns a vendor specific name and version
@@ -56,6 +123,15 @@ func (p *plugin) VendorInfo() *spi.VendorInfo {
 func (p *plugin) Validate(req *types.Any) error {
 	log.Debugln("validate", req.String())
 
+	req, err := p.withDefaultProperties(req)
+	if err != nil {
+		return err
+	}
+	return p.validateSpec(req)
+}
+
+// validateSpec validates the Properties merged with DefaultProperties
+func (p *plugin) validateSpec(req *types.Any) error {
 	spec := Spec{}
 	if err := req.Decode(&spec); err != nil {
 		return err
@@ -66,7 +142,31 @@ func (p *plugin) Validate(req *types.Any) error {
 		return err
 	}
 
-	err = validateProp(p.client, properties)
+	if errs := p.validateProperties(properties); len(errs) > 0 {
+		return flattenErrors(errs)
+	}
+
+	err = p.validateInZones(properties)
+	if err != nil {
+		return err
+	}
+
+	zone := p.defaultZone
+	if len(properties.Zones) > 0 {
+		zone = properties.Zones[0]
+	}
+
+	err = p.validatePlans(properties)
+	if err != nil {
+		return err
+	}
+
+	err = validateStartupScripts(zone, "", properties)
+	if err != nil {
+		return err
+	}
+
+	err = p.validateLogicalIDOverrides(req, properties)
 	if err != nil {
 		return err
 	}
@@ -75,22 +175,45 @@ func (p *plugin) Validate(req *types.Any) error {
 	return nil
 }
 
+// validateProperties validates the Properties without API calls
+func (p *plugin) validateProperties(properties instance_types.Properties) []error {
+	errs := validateInStrValues("ZoneStrategy", properties.ZoneStrategy, zoneStrategyRoundRobin, zoneStrategyLeastPopulated)
+	errs = append(errs, validateInStrValues("DestroyPolicy.Mode", properties.DestroyPolicy.Mode, instance_types.DestroyModes...)...)
+	errs = append(errs, validateInStrValues("DestroyPolicy.RollingUpdate", properties.DestroyPolicy.RollingUpdate, instance_types.DestroyModes...)...)
+	errs = append(errs, validateInStrValues("DestroyPolicy.Termination", properties.DestroyPolicy.Termination, instance_types.DestroyModes...)...)
+	errs = append(errs, validateArchiveSelector(properties)...)
+	if properties.GenerateSSHKey && p.options.KeyDir == "" {
+		errs = append(errs, fmt.Errorf("%q: the key directory(--key-dir) is not configured", "GenerateSSHKey"))
+	}
+	for _, zone := range properties.Zones {
+		if _, ok := p.clients[zone]; !ok {
+			errs = append(errs, fmt.Errorf("%q: zone %q is not managed by this plugin", "Zones", zone))
+		}
+	}
+	return errs
+}
+
 // Label labels the instance
 func (p *plugin) Label(instance instance.ID, labels map[string]string) error {
 	log.Debugf("label instance %s with %v", instance, labels)
-	id, err := strconv.ParseInt(string(instance), 10, 64)
+	client, id, err := p.resolveInstance(instance)
 	if err != nil {
 		return err
 	}
-	server, err := p.client.Server.Read(id)
+	server, err := client.Server.Read(id)
 	if err != nil {
 		return err
 	}
 
-	tags := doTags(mapToStringSlice(labels))
-	server.Description = tags
+	tags, err := applyLabels(infrakitTags(server), labels, p.namespace())
+	if err != nil {
+		return err
+	}
+	// servers created by older versions are migrated to the current tag encoding here
+	tags[instance_types.InfrakitSakuraCloudVersion] = instance_types.InfrakitSakuraCloudCurrentVersion
+	setInstanceTags(server, tags)
 
-	_, err = p.client.Server.Update(id, server)
+	_, err = client.Server.Update(id, server)
 	if err != nil {
 		return err
 	}
@@ -100,7 +223,75 @@ func (p *plugin) Label(instance instance.ID, labels map[string]string) error {
 
 // Provision creates a new instance based on the spec.
 func (p *plugin) Provision(spec instance.Spec) (*instance.ID, error) {
-	properties, err := instance_types.ParseProperties(spec.Properties)
+	p.beginProvision()
+	defer p.endProvision()
+
+	logicalID := ""
+	if spec.LogicalID != nil {
+		logicalID = string(*spec.LogicalID)
+	}
+	l := p.events.begin("", "", logicalID)
+	l.publish(topicProvisionStart, nil)
+
+	id, err := p.provision(spec, l)
+	p.record(counterProvisioned, err)
+	if err != nil {
+		l.publish(topicProvisionFailed, err)
+	}
+	return id, err
+}
+
+func (p *plugin) provision(spec instance.Spec, l *lifecycle) (*instance.ID, error) {
+	logicalID := ""
+	if spec.LogicalID != nil {
+		logicalID = string(*spec.LogicalID)
+	}
+	req, err := p.withDefaultProperties(spec.Properties)
+	if err != nil {
+		return nil, err
+	}
+	properties, err := instance_types.ParsePropertiesFor(req, logicalID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Provision may be retried for the same logical ID, return the existing instance then
+	if spec.LogicalID != nil {
+		logicalID := *spec.LogicalID
+		if err := p.beginProvisioning(logicalID); err != nil {
+			return nil, err
+		}
+		defer p.endProvisioning(logicalID)
+
+		ids, err := p.findByLogicalID(logicalID)
+		if err != nil {
+			return nil, err
+		}
+		switch len(ids) {
+		case 0:
+		case 1:
+			log.Infof("Instance %s with logical ID %s already exists", ids[0], logicalID)
+			return &ids[0], nil
+		default:
+			return nil, fmt.Errorf("Conflicting instances with logical ID %s: %v", logicalID, ids)
+		}
+	}
+
+	if errs := validateAttachments(spec.Attachments, properties); len(errs) > 0 {
+		return nil, flattenErrors(errs)
+	}
+
+	zone, err := p.selectZone(properties, spec)
+	if err != nil {
+		return nil, err
+	}
+	l.zone = zone
+	client, err := p.clientFor(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	properties, err = resolveSourceArchive(client, properties)
 	if err != nil {
 		return nil, err
 	}
@@ -110,52 +301,158 @@ func (p *plugin) Provision(spec instance.Spec) (*instance.ID, error) {
 
 	// tags to include namespace tags and injected tags
 	tags := instance_types.ParseTags(spec)
-	_, tags = mergeTags(tags, sliceToMap(properties.Tags), p.namespaceTags) // scope this resource with namespace tags
-	properties.Description = doTags(mapToStringSlice(tags))
+	_, tags = mergeTags(tags, p.namespace()) // scope this resource with namespace tags
+	tags[instance_types.InfrakitDestroyPolicy] = properties.DestroyPolicy.String()
+	// created disks are recorded after the build, no disk is deleted on Destroy until then
+	tags[instance_types.InfrakitCreatedDisks] = ""
+	if len(spec.Attachments) > 0 {
+		tags[instance_types.InfrakitAttachments] = formatAttachments(spec.Attachments)
+	}
+	if properties.DiskMode == "create" && properties.SourceArchiveID > 0 {
+		tags[instance_types.InfrakitSourceArchive] = fmt.Sprintf("%d", properties.SourceArchiveID)
+	}
+	if properties.GenerateSSHKey {
+		tags[instance_types.InfrakitGeneratedSSHKey] = "true"
+	}
+	properties.Tags = append(properties.Tags, instance_types.EncodeTags(tags)...)
+
+	// render scripts with the context of this instance
+	properties, err = loadStartupScriptFiles(properties)
+	if err != nil {
+		return nil, err
+	}
+	unrendered := properties.StartupScripts
+	ctx := newScriptContext(zone, logicalID, tags, properties)
+	properties, err = renderStartupScripts(properties, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !properties.StartupScriptsEphemeral {
+		properties, err = persistSharedScripts(client, properties, unrendered)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Set init script
 	if spec.Init != "" {
-		properties.StartupScripts = append(properties.StartupScripts, fmt.Sprintf(startupScriptTemplate, spec.Init))
+		init := spec.Init
+		if !properties.DisableScriptTemplates {
+			init, err = renderScript(spec.Init, ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%q: rendering template is failed: %s", "Init", err)
+			}
+		}
+		properties.StartupScripts = append(properties.StartupScripts, fmt.Sprintf(startupScriptTemplate, init))
 	}
 
-	res, err := createInstance(p.client, properties)
+	var onBuilt func(*builder.ServerBuildResult) error
+	if properties.GenerateSSHKey {
+		onBuilt = func(res *builder.ServerBuildResult) error {
+			return writePrivateKey(p.options.KeyDir, newInstanceID(zone, res.Server.ID), res)
+		}
+	}
+
+	res, err := createInstance(client, properties, spec.Attachments, onBuilt, l)
 	if err != nil {
 		return nil, err
 	}
-	id := instance.ID(res.GetStrID())
+	id := newInstanceID(zone, res.Server.ID)
 	return &id, nil
 }
 
+// beginProvisioning marks the logical ID as being provisioned, and fails if it is already marked
+func (p *plugin) beginProvisioning(logicalID instance.LogicalID) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.provisioning[logicalID] {
+		return fmt.Errorf("Instance with logical ID %s is being provisioned", logicalID)
+	}
+	p.provisioning[logicalID] = true
+	return nil
+}
+
+func (p *plugin) endProvisioning(logicalID instance.LogicalID) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.provisioning, logicalID)
+}
+
 // Destroy terminates an existing instance.
 func (p *plugin) Destroy(instance instance.ID, ctx instance.Context) error {
-	id, err := strconv.ParseInt(string(instance), 10, 64)
+	p.beginDestroying(instance)
+	defer p.endDestroying(instance)
+
+	err := p.destroy(instance, ctx)
+	p.record(counterDestroyed, err)
+	return err
+}
+
+func (p *plugin) destroy(instance instance.ID, ctx instance.Context) error {
+	client, id, err := p.resolveInstance(instance)
 	if err != nil {
 		return err
 	}
 
-	api := p.client.GetServerAPI()
+	api := client.GetServerAPI()
 
 	s, err := api.Read(id)
 	if err != nil {
 		return fmt.Errorf("Destroy is failed: %s", err)
 	}
 
-	if s.IsUp() {
+	policy := instance_types.ParseDestroyPolicy(infrakitTags(s)[instance_types.InfrakitDestroyPolicy])
+	mode := policy.ModeFor(ctx.Reason)
+	log.Debugf("destroy instance %s(mode: %s)", instance, mode)
+
+	l := p.events.begin(client.Zone, instance, infrakitTags(s)[instance_types.InfrakitLogicalID])
+	l.publish(topicDestroyStart, nil)
 
-		_, err = api.Stop(id)
+	if s.IsUp() {
+		err = shutdownServer(client, id, p.options.ShutdownPolicy, ctx)
 		if err != nil {
 			return fmt.Errorf("Destroy is failed: %s", err)
 		}
+	}
+	l.publish(topicDestroyStopped, nil)
 
-		err = api.SleepUntilDown(id, p.client.DefaultTimeoutDuration)
+	if mode == instance_types.DestroyModePowerOff {
+		err = markDestroyed(client, s, ctx)
 		if err != nil {
 			return fmt.Errorf("Destroy is failed: %s", err)
 		}
+		return nil
+	}
+
+	// attached resources are never deleted
+	attachments := parseAttachments(infrakitTags(s)[instance_types.InfrakitAttachments])
+	err = detachResources(client, s, attachments)
+	if err != nil {
+		return fmt.Errorf("Destroy is failed: %s", err)
+	}
+
+	// disks not created by the plugin(e.g. DiskMode "connect" or attachments) are never deleted
+	owned, others := ownedDiskIDs(s)
+	err = detachDisks(client, instance, others)
+	if err != nil {
+		return fmt.Errorf("Destroy is failed: %s", err)
+	}
+
+	switch mode {
+	case instance_types.DestroyModeKeep:
+		err = keepDisks(client, instance, s, owned)
+	case instance_types.DestroyModeArchive:
+		err = archiveBootDisk(client, instance, s)
+	}
+	if err != nil {
+		return fmt.Errorf("Destroy is failed: %s", err)
 	}
 
 	// call Delete(id)
-	if len(s.Disks) > 0 {
-		_, err = api.DeleteWithDisk(id, s.GetDiskIDs())
+	if len(owned) > 0 && mode != instance_types.DestroyModeKeep {
+		_, err = api.DeleteWithDisk(id, owned)
 		if err != nil {
 			return fmt.Errorf("Destroy is failed: %s", err)
 		}
@@ -165,39 +462,119 @@ func (p *plugin) Destroy(instance instance.ID, ctx instance.Context) error {
 			return fmt.Errorf("Destroy is failed: %s", err)
 		}
 	}
+	l.publish(topicDestroyDeleted, nil)
+
+	if _, ok := infrakitTags(s)[instance_types.InfrakitGeneratedSSHKey]; ok {
+		if err := removePrivateKey(p.options.KeyDir, instance); err != nil {
+			log.Warnf("Removing private key of %s is failed: %s", instance, err)
+		}
+	}
 	return nil
 }
 
+// beginDestroying marks the instance as being destroyed, so that it is not booted by the health policy
+func (p *plugin) beginDestroying(id instance.ID) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.destroying[id] = true
+}
+
+func (p *plugin) endDestroying(id instance.ID) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.destroying, id)
+}
+
 // DescribeInstances returns descriptions of all instances matching all of the provided tags.
+// Servers down on their own are booted according to the health policy.
+// Instances in zones failing to be described are not reported, an error is returned only if all zones fail.
 func (p *plugin) DescribeInstances(tags map[string]string, properties bool) ([]instance.Description, error) {
+	return p.describeInstances(tags, properties, true)
+}
+
+func (p *plugin) describeInstances(tags map[string]string, properties bool, autoBoot bool) ([]instance.Description, error) {
 	log.Debugln("describe-instances", tags)
 
-	_, tags = mergeTags(tags, p.namespaceTags)
+	_, tags = mergeTags(tags, p.namespace())
+
+	result := []instance.Description{}
+	zones := p.managedZones()
+	errs := []error{}
+	for _, zone := range zones {
+		descriptions, err := p.describeZone(zone, tags, properties, autoBoot)
+		if err != nil {
+			log.Warnf("Skipping instances in zone %s: %s", zone, err)
+			errs = append(errs, err)
+			continue
+		}
+		result = append(result, descriptions...)
+	}
+	if len(errs) == len(zones) {
+		return nil, flattenErrors(errs)
+	}
+
+	for logicalID, ids := range duplicateLogicalIDs(result) {
+		log.Warnf("Conflicting instances with logical ID %s: %v", logicalID, ids)
+	}
+
+	return result, nil
+}
+
+func (p *plugin) describeZone(zone string, tags map[string]string, properties bool, autoBoot bool) ([]instance.Description, error) {
+	client, err := p.clientFor(zone)
+	if err != nil {
+		return nil, err
+	}
 
 	result := []instance.Description{}
 
-	res, err := p.client.Server.Find()
+	instances, err := findServers(client, tags)
 	if err != nil {
 		return nil, err
 	}
-	instances := res.Servers
 
-	log.Debugln("total count:", len(instances))
+	log.Debugf("total count in %s: %d", zone, len(instances))
 
 	for _, server := range instances {
-		instTags := sliceToMap(undoTags(server.Description))
+		instTags := instanceTags(&server)
 		if hasDifferentTag(tags, instTags) {
 			log.Debugf("Skipping %v", server.Name)
 			continue
 		}
+		if _, destroyed := instTags[instance_types.InfrakitDestroyed]; destroyed {
+			log.Debugf("Skipping %v: already destroyed", server.Name)
+			continue
+		}
+
+		id := newInstanceID(zone, server.ID)
+		if reason := p.checkHealth(client, id, &server, autoBoot); reason != "" {
+			if p.options.HealthPolicy.Mode == healthModeExclude {
+				log.Warnf("Skipping %s: unhealthy(%s)", id, reason)
+				continue
+			}
+			instTags[instance_types.InfrakitHealth] = reason
+		}
+
+		if recorded, ok := instTags[instance_types.InfrakitAttachments]; ok {
+			instTags[instance_types.InfrakitAttachments] = formatAttachments(currentAttachments(&server, parseAttachments(recorded)))
+		}
+		if _, ok := instTags[instance_types.InfrakitGeneratedSSHKey]; ok {
+			instTags[instance_types.InfrakitSSHKeyFile] = privateKeyPath(p.options.KeyDir, id)
+		}
 
 		description := instance.Description{
-			ID:   instance.ID(fmt.Sprintf("%d", server.ID)),
+			ID:   id,
 			Tags: instTags,
 		}
+		if logicalID, ok := instTags[instance_types.InfrakitLogicalID]; ok && logicalID != "" {
+			id := instance.LogicalID(logicalID)
+			description.LogicalID = &id
+		}
 
 		if properties {
-			if any, err := types.AnyValue(server); err == nil {
+			if any, err := types.AnyValue(describeServer(zone, &server)); err == nil {
 				description.Properties = any
 			} else {
 				log.Warningln("error encoding instance properties:", err)
@@ -216,10 +593,6 @@ var startupScriptTemplate = `#!/bin/sh
 %s
 exit 0`
 
-func doTags(tags []string) string {
-	return strings.Join(tags, "\n")
-}
-
 func undoTags(tags string) []string {
 	return strings.Split(tags, "\n")
 }
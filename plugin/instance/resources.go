@@ -0,0 +1,183 @@
+package instance
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+)
+
+const (
+	resourceSwitch       = "switch"
+	resourcePacketFilter = "packet filter"
+	resourceDisk         = "disk"
+	resourceArchive      = "archive"
+	resourceISOImage     = "ISO image"
+	resourceIcon         = "icon"
+	resourceSSHKey       = "SSH key"
+	resourceNote         = "startup script"
+)
+
+// resourceReaders look up a resource by ID, and return an error if it doesn't exist
+var resourceReaders = map[string]func(client *api.Client, id int64) error{
+	resourceSwitch: func(client *api.Client, id int64) error {
+		_, err := client.GetSwitchAPI().Read(id)
+		return err
+	},
+	resourcePacketFilter: func(client *api.Client, id int64) error {
+		_, err := client.GetPacketFilterAPI().Read(id)
+		return err
+	},
+	resourceDisk: func(client *api.Client, id int64) error {
+		_, err := client.GetDiskAPI().Read(id)
+		return err
+	},
+	resourceArchive: func(client *api.Client, id int64) error {
+		_, err := client.GetArchiveAPI().Read(id)
+		return err
+	},
+	resourceISOImage: func(client *api.Client, id int64) error {
+		_, err := client.GetCDROMAPI().Read(id)
+		return err
+	},
+	resourceIcon: func(client *api.Client, id int64) error {
+		_, err := client.GetIconAPI().Read(id)
+		return err
+	},
+	resourceSSHKey: func(client *api.Client, id int64) error {
+		_, err := client.GetSSHKeyAPI().Read(id)
+		return err
+	},
+	resourceNote: func(client *api.Client, id int64) error {
+		_, err := client.GetNoteAPI().Read(id)
+		return err
+	},
+}
+
+// resourceRef is a resource ID referenced by a field of the Properties
+type resourceRef struct {
+	field    string
+	resource string
+	id       int64
+}
+
+// resourceRefs returns all resource IDs referenced by the Properties. Zero IDs are not included.
+func resourceRefs(params instance_types.Properties) []resourceRef {
+	refs := []resourceRef{}
+	add := func(field, resource string, id int64) {
+		if id != 0 {
+			refs = append(refs, resourceRef{field: field, resource: resource, id: id})
+		}
+	}
+
+	add("SwitchID", resourceSwitch, params.SwitchID)
+	add("PacketFilterID", resourcePacketFilter, params.PacketFilterID)
+	for i, nic := range params.Networks {
+		add(fmt.Sprintf("Networks[%d].SwitchID", i), resourceSwitch, nic.SwitchID)
+		add(fmt.Sprintf("Networks[%d].PacketFilterID", i), resourcePacketFilter, nic.PacketFilterID)
+	}
+
+	add("DiskID", resourceDisk, params.DiskID)
+	add("SourceArchiveID", resourceArchive, params.SourceArchiveID)
+	add("SourceDiskID", resourceDisk, params.SourceDiskID)
+	for i, id := range params.DistantFrom {
+		add(fmt.Sprintf("DistantFrom[%d]", i), resourceDisk, id)
+	}
+	for i, d := range params.AdditionalDisks {
+		add(fmt.Sprintf("AdditionalDisks[%d].SourceArchiveID", i), resourceArchive, d.SourceArchiveID)
+		add(fmt.Sprintf("AdditionalDisks[%d].SourceDiskID", i), resourceDisk, d.SourceDiskID)
+		for j, id := range d.DistantFrom {
+			add(fmt.Sprintf("AdditionalDisks[%d].DistantFrom[%d]", i, j), resourceDisk, id)
+		}
+	}
+
+	add("ISOImageID", resourceISOImage, params.ISOImageID)
+	add("IconID", resourceIcon, params.IconID)
+	for i, id := range params.SSHKeyIDs {
+		add(fmt.Sprintf("SSHKeyIDs[%d]", i), resourceSSHKey, id)
+	}
+	for i, id := range params.StartupScriptIDs {
+		add(fmt.Sprintf("StartupScriptIDs[%d]", i), resourceNote, id)
+	}
+	return refs
+}
+
+// validateResources checks the format of resource IDs referenced by the Properties, and that they exist in the zone
+func validateResources(client *api.Client, params instance_types.Properties) error {
+	errs := []error{}
+	found := map[resourceRef]error{}
+
+	for _, ref := range resourceRefs(params) {
+		if e := validateSakuraID(ref.field, ref.id); len(e) > 0 {
+			errs = append(errs, e...)
+			continue
+		}
+
+		// each resource is looked up only once
+		key := resourceRef{resource: ref.resource, id: ref.id}
+		err, ok := found[key]
+		if !ok {
+			err = resourceReaders[ref.resource](client, ref.id)
+			found[key] = err
+		}
+		if err != nil {
+			if !isNotFound(err) {
+				return err
+			}
+			errs = append(errs, fmt.Errorf("%q: %s %d is not found in zone %s", ref.field, ref.resource, ref.id, client.Zone))
+		}
+	}
+	return flattenErrors(errs)
+}
+
+// apiError is implemented by errors of the API reporting the response code, such as api.Error of newer libsacloud
+type apiError interface {
+	error
+	ResponseCode() int
+}
+
+// isNotFound returns true if the error is a 404 response of the API
+func isNotFound(err error) bool {
+	if e, ok := err.(apiError); ok {
+		return e.ResponseCode() == http.StatusNotFound
+	}
+	// the vendored libsacloud has no error type, and reports errors only as a formatted sacloud.ResultErrorValue
+	s := err.Error()
+	return strings.Contains(s, `Status:"404`) || strings.Contains(s, `ErrorCode:"not_found"`)
+}
+
+// validateInZones validates the Properties with resources in each of Zones(the default zone if empty).
+// SourceArchive is resolved in each zone.
+func (p *plugin) validateInZones(params instance_types.Properties) error {
+	zones := params.Zones
+	if len(zones) == 0 {
+		zones = []string{p.defaultZone}
+	}
+
+	errs := []error{}
+	reported := map[string]bool{}
+	add := func(err error) {
+		// errors not depending on the zone are reported once
+		if err != nil && !reported[err.Error()] {
+			reported[err.Error()] = true
+			errs = append(errs, err)
+		}
+	}
+	for _, zone := range zones {
+		client, err := p.clientFor(zone)
+		if err != nil {
+			add(err)
+			continue
+		}
+		resolved, err := resolveSourceArchive(client, params)
+		if err != nil {
+			add(err)
+			continue
+		}
+		add(validateProp(client, resolved))
+		add(validateResources(client, resolved))
+	}
+	return flattenErrors(errs)
+}
@@ -0,0 +1,123 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// errNotFound is formatted as libsacloud reports a 404 response
+var errNotFound = errors.New(`Error in response: &sacloud.ResultErrorValue{IsFatal:true, Serial:"", Status:"404 Not Found", ErrorCode:"not_found", ErrorMessage:"対象が見つかりません"}`)
+
+// stubResourceReaders replaces resourceReaders with ones finding only given IDs, and returns a function restoring them
+func stubResourceReaders(existing ...int64) (map[string]int, func()) {
+	original := resourceReaders
+	calls := map[string]int{}
+
+	resourceReaders = map[string]func(*api.Client, int64) error{}
+	for resource := range original {
+		resource := resource
+		resourceReaders[resource] = func(client *api.Client, id int64) error {
+			calls[resource]++
+			for _, e := range existing {
+				if e == id {
+					return nil
+				}
+			}
+			return errNotFound
+		}
+	}
+	return calls, func() { resourceReaders = original }
+}
+
+func TestValidateResources(t *testing.T) {
+	calls, restore := stubResourceReaders(112233445566, 112233445567)
+	defer restore()
+
+	client := api.NewClient("token", "secret", "tk1a")
+	params := instance_types.Properties{
+		SwitchID:         112233445566,
+		SourceArchiveID:  112233445567,
+		DistantFrom:      []int64{112233445568, 112233445568},
+		SSHKeyIDs:        []int64{123},
+		StartupScriptIDs: []int64{},
+	}
+
+	err := validateResources(client, params)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"DistantFrom[0]": disk 112233445568 is not found in zone tk1a`)
+	assert.Contains(t, err.Error(), `"DistantFrom[1]": disk 112233445568 is not found in zone tk1a`)
+	assert.Contains(t, err.Error(), `"SSHKeyIDs[0]": Resource ID must be a 12 digits number`)
+	assert.NotContains(t, err.Error(), "SwitchID")
+
+	// same resources are looked up only once, and malformed IDs are not looked up
+	assert.Equal(t, map[string]int{resourceSwitch: 1, resourceArchive: 1, resourceDisk: 1}, calls)
+
+	params = instance_types.Properties{SwitchID: 112233445566}
+	assert.NoError(t, validateResources(client, params))
+}
+
+func TestValidateResourcesAPIError(t *testing.T) {
+	_, restore := stubResourceReaders()
+	defer restore()
+
+	apiErr := errors.New(`Error in response: &sacloud.ResultErrorValue{IsFatal:true, Serial:"", Status:"503 Service Unavailable", ErrorCode:"service_unavailable", ErrorMessage:""}`)
+	resourceReaders[resourceSwitch] = func(client *api.Client, id int64) error {
+		return apiErr
+	}
+
+	err := validateResources(api.NewClient("token", "secret", "tk1a"), instance_types.Properties{SwitchID: 112233445566})
+	assert.Equal(t, apiErr, err)
+}
+
+// testAPIError is an API error with the response code
+type testAPIError struct {
+	code int
+}
+
+func (e testAPIError) Error() string {
+	return fmt.Sprintf("Error in response: %d", e.code)
+}
+
+func (e testAPIError) ResponseCode() int {
+	return e.code
+}
+
+func TestIsNotFound(t *testing.T) {
+	assert.True(t, isNotFound(errNotFound))
+	assert.False(t, isNotFound(errors.New(`Error in response: &sacloud.ResultErrorValue{IsFatal:true, Serial:"", Status:"503 Service Unavailable", ErrorCode:"service_unavailable", ErrorMessage:""}`)))
+
+	// the response code is used if available
+	assert.True(t, isNotFound(testAPIError{code: 404}))
+	assert.False(t, isNotFound(testAPIError{code: 503}))
+}
+
+func TestValidateInZones(t *testing.T) {
+	_, restore := stubResourceReaders()
+	defer restore()
+
+	// the switch exists only in tk1a
+	resourceReaders[resourceSwitch] = func(client *api.Client, id int64) error {
+		if client.Zone == "tk1a" {
+			return nil
+		}
+		return errNotFound
+	}
+
+	p := NewSakuraCloudInstancePlugin(api.NewClient("token", "secret", "tk1a"), map[string]string{}, Options{Zones: []string{"is1b"}}).(*plugin)
+	params := instance_types.Properties{
+		DiskMode:    "diskless",
+		NetworkMode: "switch",
+		SwitchID:    112233445566,
+	}
+
+	assert.NoError(t, p.validateInZones(params))
+
+	params.Zones = []string{"tk1a", "is1b"}
+	err := p.validateInZones(params)
+	assert.EqualError(t, err, `"SwitchID": switch 112233445566 is not found in zone is1b`)
+}
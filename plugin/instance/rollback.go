@@ -0,0 +1,182 @@
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/builder"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+// buildTracker keeps track of resources created by a server build, so that they can be deleted when the build fails
+type buildTracker struct {
+	server *sacloud.Server
+	disks  []*builder.DiskBuildResult
+
+	// ephemeral notes and SSH keys already deleted by the builder
+	cleanedNotes   map[*builder.DiskBuildResult]bool
+	cleanedSSHKeys map[*builder.DiskBuildResult]bool
+}
+
+func newBuildTracker() *buildTracker {
+	return &buildTracker{
+		cleanedNotes:   map[*builder.DiskBuildResult]bool{},
+		cleanedSSHKeys: map[*builder.DiskBuildResult]bool{},
+	}
+}
+
+func (t *buildTracker) trackServer(result *builder.ServerBuildResult) {
+	if result != nil && result.Server != nil {
+		t.server = result.Server
+	}
+}
+
+func (t *buildTracker) trackDisk(result *builder.DiskBuildResult) {
+	if result == nil {
+		return
+	}
+	for _, d := range t.disks {
+		if d == result {
+			return
+		}
+	}
+	t.disks = append(t.disks, result)
+}
+
+// API calls of rollback, replaced in tests
+var (
+	rollbackStopServer = stopServer
+
+	// rollbackDeleteServer deletes the server with the disks, disks connected to a server can't be deleted alone
+	rollbackDeleteServer = func(client *api.Client, serverID int64, diskIDs []int64) error {
+		var err error
+		if len(diskIDs) > 0 {
+			_, err = client.GetServerAPI().DeleteWithDisk(serverID, diskIDs)
+		} else {
+			_, err = client.GetServerAPI().Delete(serverID)
+		}
+		return err
+	}
+	rollbackDeleteDisk = func(client *api.Client, id int64) error {
+		_, err := client.GetDiskAPI().Delete(id)
+		return err
+	}
+	rollbackDeleteNote = func(client *api.Client, id int64) error {
+		_, err := client.GetNoteAPI().Delete(id)
+		return err
+	}
+	rollbackDeleteSSHKey = func(client *api.Client, id int64) error {
+		_, err := client.GetSSHKeyAPI().Delete(id)
+		return err
+	}
+)
+
+// rollback deletes the tracked resources in reverse order of creation.
+// The server is deleted together with its disks, then startup scripts and SSH keys are deleted.
+// It returns descriptions of deleted resources, and errors of resources which could not be deleted.
+func (t *buildTracker) rollback(client *api.Client) ([]string, []error) {
+	deleted := []string{}
+	errs := []error{}
+
+	diskIDs := []int64{}
+	for i := len(t.disks) - 1; i >= 0; i-- {
+		if d := t.disks[i].Disk; d != nil {
+			diskIDs = append(diskIDs, d.ID)
+		}
+	}
+
+	if t.server != nil {
+		if err := rollbackStopServer(client, t.server.ID); err != nil {
+			errs = append(errs, fmt.Errorf("server %d: %s", t.server.ID, err))
+		}
+		if err := rollbackDeleteServer(client, t.server.ID, diskIDs); err != nil {
+			errs = append(errs, fmt.Errorf("server %d: %s", t.server.ID, err))
+			for _, id := range diskIDs {
+				errs = append(errs, fmt.Errorf("disk %d: deleting with server %d is failed", id, t.server.ID))
+			}
+		} else {
+			deleted = append(deleted, fmt.Sprintf("server %d", t.server.ID))
+			for _, id := range diskIDs {
+				deleted = append(deleted, fmt.Sprintf("disk %d", id))
+			}
+		}
+	} else {
+		for _, id := range diskIDs {
+			if err := rollbackDeleteDisk(client, id); err != nil {
+				errs = append(errs, fmt.Errorf("disk %d: %s", id, err))
+			} else {
+				deleted = append(deleted, fmt.Sprintf("disk %d", id))
+			}
+		}
+	}
+
+	for i := len(t.disks) - 1; i >= 0; i-- {
+		d := t.disks[i]
+
+		if !t.cleanedNotes[d] {
+			for j := len(d.Notes) - 1; j >= 0; j-- {
+				if err := rollbackDeleteNote(client, d.Notes[j].ID); err != nil {
+					errs = append(errs, fmt.Errorf("note %d: %s", d.Notes[j].ID, err))
+				} else {
+					deleted = append(deleted, fmt.Sprintf("note %d", d.Notes[j].ID))
+				}
+			}
+		}
+
+		if !t.cleanedSSHKeys[d] {
+			keyIDs := []int64{}
+			for _, key := range d.SSHKeys {
+				keyIDs = append(keyIDs, key.ID)
+			}
+			if d.GeneratedSSHKey != nil {
+				keyIDs = append(keyIDs, d.GeneratedSSHKey.ID)
+			}
+			for j := len(keyIDs) - 1; j >= 0; j-- {
+				if err := rollbackDeleteSSHKey(client, keyIDs[j]); err != nil {
+					errs = append(errs, fmt.Errorf("ssh key %d: %s", keyIDs[j], err))
+				} else {
+					deleted = append(deleted, fmt.Sprintf("ssh key %d", keyIDs[j]))
+				}
+			}
+		}
+	}
+
+	return deleted, errs
+}
+
+// rollbackError rolls back the build, and returns an error describing the cause and the result of the rollback
+func (t *buildTracker) rollbackError(client *api.Client, cause error) error {
+	deleted, errs := t.rollback(client)
+	for _, r := range deleted {
+		log.Infof("Rolled back %s", r)
+	}
+
+	msg := fmt.Sprintf("CreateInstance is failed: %s", cause)
+	if len(deleted) > 0 {
+		msg += fmt.Sprintf("\nrolled back: %s", strings.Join(deleted, ", "))
+	}
+	if len(errs) > 0 {
+		msg += fmt.Sprintf("\ncould not clean up: %s", strings.Replace(flattenErrors(errs).Error(), "\n", ", ", -1))
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// stopServer forces the server to power off if it is up
+func stopServer(client *api.Client, id int64) error {
+	serverAPI := client.GetServerAPI()
+
+	server, err := serverAPI.Read(id)
+	if err != nil {
+		return err
+	}
+	if !server.IsUp() {
+		return nil
+	}
+
+	if _, err := serverAPI.Stop(id); err != nil {
+		return err
+	}
+	return serverAPI.SleepUntilDown(id, client.DefaultTimeoutDuration)
+}
@@ -0,0 +1,126 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/sacloud/libsacloud/api"
+
+	"github.com/sacloud/libsacloud/builder"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTracker(t *testing.T) {
+	tracker := newBuildTracker()
+
+	// results of the build without server
+	tracker.trackServer(&builder.ServerBuildResult{})
+	assert.Nil(t, tracker.server)
+
+	server := &sacloud.Server{Resource: &sacloud.Resource{ID: 100000000001}}
+	tracker.trackServer(&builder.ServerBuildResult{Server: server})
+	assert.Equal(t, server, tracker.server)
+
+	// the same disk is reported by multiple events
+	disk := &builder.DiskBuildResult{}
+	tracker.trackDisk(disk)
+	tracker.trackDisk(disk)
+	tracker.trackDisk(nil)
+	assert.Equal(t, []*builder.DiskBuildResult{disk}, tracker.disks)
+}
+
+// stubRollbackAPI replaces API calls of rollback with ones recording calls, failing for IDs in fails
+func stubRollbackAPI(fails ...int64) (*[]string, func()) {
+	calls := []string{}
+	failed := func(id int64) error {
+		for _, f := range fails {
+			if f == id {
+				return errors.New("Error in response: 503")
+			}
+		}
+		return nil
+	}
+
+	stop, deleteServer, deleteDisk, deleteNote, deleteSSHKey := rollbackStopServer, rollbackDeleteServer, rollbackDeleteDisk, rollbackDeleteNote, rollbackDeleteSSHKey
+	rollbackStopServer = func(client *api.Client, id int64) error {
+		calls = append(calls, fmt.Sprintf("stop server %d", id))
+		return nil
+	}
+	rollbackDeleteServer = func(client *api.Client, id int64, diskIDs []int64) error {
+		calls = append(calls, fmt.Sprintf("delete server %d with disks %v", id, diskIDs))
+		return failed(id)
+	}
+	rollbackDeleteDisk = func(client *api.Client, id int64) error {
+		calls = append(calls, fmt.Sprintf("delete disk %d", id))
+		return failed(id)
+	}
+	rollbackDeleteNote = func(client *api.Client, id int64) error {
+		calls = append(calls, fmt.Sprintf("delete note %d", id))
+		return failed(id)
+	}
+	rollbackDeleteSSHKey = func(client *api.Client, id int64) error {
+		calls = append(calls, fmt.Sprintf("delete ssh key %d", id))
+		return failed(id)
+	}
+	return &calls, func() {
+		rollbackStopServer, rollbackDeleteServer, rollbackDeleteDisk, rollbackDeleteNote, rollbackDeleteSSHKey = stop, deleteServer, deleteDisk, deleteNote, deleteSSHKey
+	}
+}
+
+func newRollbackTestTracker(withServer bool) *buildTracker {
+	tracker := newBuildTracker()
+	tracker.trackDisk(&builder.DiskBuildResult{
+		Disk:    &sacloud.Disk{Resource: &sacloud.Resource{ID: 200000000001}},
+		Notes:   []*sacloud.Note{{Resource: &sacloud.Resource{ID: 400000000001}}},
+		SSHKeys: []*sacloud.SSHKey{{Resource: &sacloud.Resource{ID: 500000000001}}},
+	})
+	tracker.trackDisk(&builder.DiskBuildResult{
+		Disk: &sacloud.Disk{Resource: &sacloud.Resource{ID: 200000000002}},
+	})
+	if withServer {
+		tracker.trackServer(&builder.ServerBuildResult{Server: &sacloud.Server{Resource: &sacloud.Resource{ID: 100000000001}}})
+	}
+	return tracker
+}
+
+func TestRollback(t *testing.T) {
+	calls, restore := stubRollbackAPI()
+	defer restore()
+
+	// disks connected to the server are deleted with it
+	deleted, errs := newRollbackTestTracker(true).rollback(nil)
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{
+		"stop server 100000000001",
+		"delete server 100000000001 with disks [200000000002 200000000001]",
+		"delete note 400000000001",
+		"delete ssh key 500000000001",
+	}, *calls)
+	assert.Equal(t, []string{"server 100000000001", "disk 200000000002", "disk 200000000001", "note 400000000001", "ssh key 500000000001"}, deleted)
+
+	// disks are deleted alone if the server is not created
+	*calls = []string{}
+	_, errs = newRollbackTestTracker(false).rollback(nil)
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{
+		"delete disk 200000000002",
+		"delete disk 200000000001",
+		"delete note 400000000001",
+		"delete ssh key 500000000001",
+	}, *calls)
+}
+
+func TestRollbackError(t *testing.T) {
+	_, restore := stubRollbackAPI(100000000001, 500000000001)
+	defer restore()
+
+	err := newRollbackTestTracker(true).rollbackError(nil, errors.New("Booting server is failed"))
+	assert.EqualError(t, err, "CreateInstance is failed: Booting server is failed\n"+
+		"rolled back: note 400000000001\n"+
+		"could not clean up: server 100000000001: Error in response: 503, "+
+		"disk 200000000002: deleting with server 100000000001 is failed, "+
+		"disk 200000000001: deleting with server 100000000001 is failed, "+
+		"ssh key 500000000001: Error in response: 503")
+}
@@ -0,0 +1,84 @@
+package instance
+
+import (
+	"fmt"
+
+	"github.com/docker/infrakit/pkg/template"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/infrakit.sakuracloud/version"
+)
+
+// scriptContext is the context of templates in spec.Init and StartupScripts, such as "{{ .Name }}"
+type scriptContext struct {
+	Name      string
+	LogicalID string
+	Zone      string
+	Tags      map[string]string
+	Hostname  string
+
+	// IPAddress is the address of eth0, empty if it is not assigned by the plugin
+	IPAddress string
+
+	// Networks are NICs in order from eth0
+	Networks []instance_types.Network
+
+	PluginVersion string
+}
+
+func newScriptContext(zone, logicalID string, tags map[string]string, params instance_types.Properties) scriptContext {
+	ctx := scriptContext{
+		Name:          params.Name,
+		LogicalID:     logicalID,
+		Zone:          zone,
+		Tags:          tags,
+		Hostname:      params.Hostname,
+		Networks:      params.NetworkInterfaces(),
+		PluginVersion: version.Version,
+	}
+	if len(ctx.Networks) > 0 {
+		ctx.IPAddress = ctx.Networks[0].IPAddress
+	}
+	if ctx.Tags == nil {
+		ctx.Tags = map[string]string{}
+	}
+	return ctx
+}
+
+// renderScript renders the script as a template of the infrakit template engine
+func renderScript(script string, ctx scriptContext) (string, error) {
+	t, err := template.NewTemplate("str://"+script, template.Options{})
+	if err != nil {
+		return "", err
+	}
+	return t.Render(ctx)
+}
+
+// renderStartupScripts returns the Properties with rendered StartupScripts, or as they are with DisableScriptTemplates
+func renderStartupScripts(params instance_types.Properties, ctx scriptContext) (instance_types.Properties, error) {
+	if params.DisableScriptTemplates {
+		return params, nil
+	}
+	rendered := []string{}
+	for i, script := range params.StartupScripts {
+		s, err := renderScript(script, ctx)
+		if err != nil {
+			return params, fmt.Errorf("%q: rendering template is failed: %s", fmt.Sprintf("StartupScripts[%d]", i), err)
+		}
+		rendered = append(rendered, s)
+	}
+	if len(rendered) > 0 {
+		params.StartupScripts = rendered
+	}
+	return params, nil
+}
+
+// validateStartupScripts loads StartupScriptFiles, and renders StartupScripts with a context of an instance to be provisioned, to find errors
+func validateStartupScripts(zone, logicalID string, params instance_types.Properties) error {
+	params, err := loadStartupScriptFiles(params)
+	if err != nil {
+		return err
+	}
+	params.Name = fmt.Sprintf("%s-%s", params.NamePrefix, randomSuffix(6))
+	_, err = renderStartupScripts(params, newScriptContext(zone, logicalID, map[string]string{}, params))
+	return err
+}
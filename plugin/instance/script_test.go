@@ -0,0 +1,59 @@
+package instance
+
+import (
+	"testing"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderStartupScripts(t *testing.T) {
+	params := instance_types.Properties{
+		Name:        "etcd-abcdef",
+		Hostname:    "etcd-1",
+		NetworkMode: "switch",
+		SwitchID:    112233445566,
+		IPAddress:   "192.168.0.11",
+		NwMasklen:   24,
+		StartupScripts: []string{
+			"echo {{ .Name }} {{ .LogicalID }} {{ .Zone }} {{ .Hostname }}",
+			"echo {{ .IPAddress }}/{{ (index .Networks 0).NwMasklen }} {{ index .Tags \"infrakit.group\" }}",
+		},
+	}
+	ctx := newScriptContext("tk1a", "etcd-1", map[string]string{"infrakit.group": "etcd"}, params)
+
+	rendered, err := renderStartupScripts(params, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"echo etcd-abcdef etcd-1 tk1a etcd-1",
+		"echo 192.168.0.11/24 etcd",
+	}, rendered.StartupScripts)
+
+	params.StartupScripts = []string{"echo ok", "echo {{ .Name "}
+	_, err = renderStartupScripts(params, ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"StartupScripts[1]": rendering template is failed`)
+
+	params.StartupScripts = []string{"echo {{ .Unknown }}"}
+	assert.Error(t, validateStartupScripts("tk1a", "", params))
+}
+
+func TestRenderStartupScriptsDisabled(t *testing.T) {
+	params := instance_types.Properties{
+		Name:                   "etcd-abcdef",
+		DisableScriptTemplates: true,
+		StartupScripts: []string{
+			"docker inspect -f '{{.State.Status}}' etcd",
+		},
+	}
+	ctx := newScriptContext("tk1a", "etcd-1", map[string]string{}, params)
+
+	rendered, err := renderStartupScripts(params, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docker inspect -f '{{.State.Status}}' etcd"}, rendered.StartupScripts)
+	assert.NoError(t, validateStartupScripts("tk1a", "", params))
+
+	// a literal "{{" is broken without DisableScriptTemplates
+	params.DisableScriptTemplates = false
+	assert.Error(t, validateStartupScripts("tk1a", "", params))
+}
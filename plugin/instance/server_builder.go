@@ -3,6 +3,7 @@ package instance
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/instance"
 	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
 	"github.com/sacloud/libsacloud/api"
 	"github.com/sacloud/libsacloud/builder"
@@ -18,6 +19,11 @@ func validateProp(client *api.Client, params instance_types.Properties) error {
 	if len(errs) > 0 {
 		return fmt.Errorf("%s", flattenErrors(errs))
 	}
+	// validate --- for OS specific params
+	errs = validateOSTypeParams(params)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", flattenErrors(errs))
+	}
 	// select builder
 	sb := createServerBuilder(client, params)
 
@@ -34,7 +40,9 @@ func validateProp(client *api.Client, params instance_types.Properties) error {
 	return nil
 }
 
-func createInstance(client *api.Client, params instance_types.Properties) (*sacloud.Server, error) {
+// createInstance builds the server. onBuilt is called after the build if it is not nil, and the build is rolled back if it fails.
+// Progress of the build is published with the lifecycle, which can be nil.
+func createInstance(client *api.Client, params instance_types.Properties, attachments []instance.Attachment, onBuilt func(*builder.ServerBuildResult) error, l *lifecycle) (*builder.ServerBuildResult, error) {
 
 	// validate --- for disk mode params
 	errs := validateServerDiskModeParams(params)
@@ -47,20 +55,68 @@ func createInstance(client *api.Client, params instance_types.Properties) (*sacl
 
 	// handle build processes
 	for _, handler := range serverBuildHandlers {
-		err := handler(sb, params)
+		err := handler(client, sb, params)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// track created resources to roll back on failure
+	tracker := newBuildTracker()
+	handleDiskEvents(sb, tracker, l)
+	handleServerEvents(sb, tracker, l)
+
 	// call Create(id)
 	var b = sb.(serverBuilder)
+	if len(attachments) > 0 {
+		// resources can be attached only while the server is down
+		b.SetBootAfterCreate(false)
+	}
 	res, err := b.Build()
+	if res != nil {
+		tracker.trackServer(res)
+		for _, d := range res.Disks {
+			tracker.trackDisk(d)
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("CreateInstance is failed: %s", err)
+		return nil, tracker.rollbackError(client, err)
 	}
 
-	return res.Server, nil
+	err = recordCreatedDisks(client, res)
+	if err != nil {
+		return nil, tracker.rollbackError(client, fmt.Errorf("Recording created disks is failed: %s", err))
+	}
+
+	err = completeBuild(client, res, attachments, onBuilt, l)
+	if err != nil {
+		return nil, tracker.rollbackError(client, err)
+	}
+	return res, nil
+}
+
+// attachAndBoot attaches resources to the server down, and boots it
+var attachAndBoot = func(client *api.Client, serverID int64, attachments []instance.Attachment) error {
+	if err := attachResources(client, serverID, attachments); err != nil {
+		return err
+	}
+	return bootServer(client, serverID)
+}
+
+// completeBuild runs steps after the build. onBuilt is called last,
+// so that nothing it leaves(e.g. private keys) remains when an earlier step fails and the build is rolled back.
+func completeBuild(client *api.Client, res *builder.ServerBuildResult, attachments []instance.Attachment, onBuilt func(*builder.ServerBuildResult) error, l *lifecycle) error {
+	if len(attachments) > 0 {
+		if err := attachAndBoot(client, res.Server.ID, attachments); err != nil {
+			return err
+		}
+		l.notify(topicProvisionBooted, res.Server.ID)
+	}
+
+	if onBuilt != nil {
+		return onBuilt(res)
+	}
+	return nil
 }
 
 func createServerBuilder(client *api.Client, params instance_types.Properties) interface{} {
@@ -93,16 +149,14 @@ func createServerBuilder(client *api.Client, params instance_types.Properties) i
 	return sb
 }
 
-var serverBuildHandlers = []func(interface{}, instance_types.Properties) error{
+var serverBuildHandlers = []func(*api.Client, interface{}, instance_types.Properties) error{
 	handleNetworkParams,
 	handleDiskEditParams,
 	handleDiskParams,
 	handleServerCommonParams,
-	handleDiskEvents,
-	handleServerEvents,
 }
 
-func handleNetworkParams(sb interface{}, params instance_types.Properties) error {
+func handleNetworkParams(client *api.Client, sb interface{}, params instance_types.Properties) error {
 	// validate --- for network params
 	errs := validateServerNetworkParams(sb, params)
 	if len(errs) > 0 {
@@ -111,42 +165,56 @@ func handleNetworkParams(sb interface{}, params instance_types.Properties) error
 
 	// set network params
 	if sb, ok := sb.(serverNetworkParams); ok {
-		switch params.NetworkMode {
-		case "shared":
-			sb.AddPublicNWConnectedNIC()
-		case "switch":
-			switch sb := sb.(type) {
-			case serverConnectSwitchParam:
-				sb.AddExistsSwitchConnectedNIC(fmt.Sprintf("%d", params.SwitchID))
-			case serverConnectSwitchParamWithEditableDisk:
-				sb.AddExistsSwitchConnectedNIC(
-					fmt.Sprintf("%d", params.SwitchID),
-					params.IPAddress,
-					params.NwMasklen,
-					params.DefaultRoute,
-				)
+		nics := params.NetworkInterfaces()
+		packetFilterIDs := []int64{}
+		hasPacketFilter := false
+
+		for _, nic := range nics {
+			switch nic.Type {
+			case "shared":
+				sb.AddPublicNWConnectedNIC()
+			case "switch":
+				switch sb := sb.(type) {
+				case serverConnectSwitchParam:
+					sb.AddExistsSwitchConnectedNIC(fmt.Sprintf("%d", nic.SwitchID))
+				case serverConnectSwitchParamWithEditableDisk:
+					// the builder sets IP address of eth0 on each call, NICs other than eth0 are configured by a startup script
+					eth0 := nics[0]
+					if eth0.Type != "switch" {
+						eth0 = instance_types.Network{}
+					}
+					sb.AddExistsSwitchConnectedNIC(
+						fmt.Sprintf("%d", nic.SwitchID),
+						eth0.IPAddress,
+						eth0.NwMasklen,
+						eth0.DefaultRoute,
+					)
+				default:
+					panic(fmt.Errorf("This server builder Can't connect to switch : %#v", sb))
+				}
+
+			case "disconnect":
+				sb.AddDisconnectedNIC()
 			default:
-				panic(fmt.Errorf("This server builder Can't connect to switch : %#v", sb))
+				panic(fmt.Errorf("Unknown NetworkMode : %s", nic.Type))
 			}
 
-		case "disconnect":
-			sb.AddDisconnectedNIC()
-		case "none":
-		// noop
-		default:
-			panic(fmt.Errorf("Unknown NetworkMode : %s", params.NetworkMode))
+			packetFilterIDs = append(packetFilterIDs, nic.PacketFilterID)
+			if nic.PacketFilterID != sacloud.EmptyID {
+				hasPacketFilter = true
+			}
 		}
 
 		sb.SetUseVirtIONetPCI(params.UseNicVirtIO)
-		if params.PacketFilterID != sacloud.EmptyID {
-			sb.SetPacketFilterIDs([]int64{params.PacketFilterID})
+		if hasPacketFilter {
+			sb.SetPacketFilterIDs(packetFilterIDs)
 		}
 	}
 
 	return nil
 }
 
-func handleDiskEditParams(sb interface{}, params instance_types.Properties) error {
+func handleDiskEditParams(client *api.Client, sb interface{}, params instance_types.Properties) error {
 	// validate --- for disk params
 	errs := validateServerDiskEditParams(sb, params)
 	if len(errs) > 0 {
@@ -155,17 +223,28 @@ func handleDiskEditParams(sb interface{}, params instance_types.Properties) erro
 
 	// set disk edit params
 	if sb, ok := sb.(serverEditDiskParam); ok {
-		sb.SetHostName(params.Hostname)
+		hostname := params.Hostname
+		if hostname == "" && params.GenerateSSHKey {
+			// the builder generates the key only on the disk edit, which doesn't run without any other edit params
+			hostname = params.Name
+		}
+		sb.SetHostName(hostname)
 		sb.SetPassword(params.Password)
 		sb.SetDisablePWAuth(params.DisablePasswordAuth)
 
 		for _, v := range params.StartupScriptIDs {
 			sb.AddNoteID(v)
 		}
-		for _, v := range params.StartupScripts {
+		scripts := append([]string{}, params.StartupScripts...)
+		if script := networkStartupScript(params.NetworkInterfaces()); script != "" {
+			scripts = append(scripts, script)
+		}
+		for _, v := range scripts {
 			sb.AddNote(v)
 		}
-		sb.SetNotesEphemeral(params.StartupScriptsEphemeral)
+		// scripts shared between instances are given in StartupScriptIDs with StartupScriptsEphemeral: false,
+		// the notes created here are specific to the instance
+		sb.SetNotesEphemeral(true)
 
 		for _, v := range params.SSHKeyIDs {
 			sb.AddSSHKeyID(v)
@@ -185,11 +264,21 @@ func handleDiskEditParams(sb interface{}, params instance_types.Properties) erro
 		}
 		sb.SetSSHKeysEphemeral(params.SSHKeyEphemeral)
 
+		if params.GenerateSSHKey {
+			description := params.GenerateSSHKeyDescription
+			if description == "" {
+				description = "generated by infrakit-instance-sakuracloud"
+			}
+			sb.SetGenerateSSHKeyName(params.Name)
+			sb.SetGenerateSSHKeyPassPhrase(params.GenerateSSHKeyPassPhrase)
+			sb.SetGenerateSSHKeyDescription(description)
+		}
+
 	}
 	return nil
 }
 
-func handleDiskParams(sb interface{}, params instance_types.Properties) error {
+func handleDiskParams(client *api.Client, sb interface{}, params instance_types.Properties) error {
 	// set disk params
 	if sb, ok := sb.(serverDiskParams); ok {
 		sb.SetDiskPlan(params.DiskPlan)
@@ -198,10 +287,28 @@ func handleDiskParams(sb interface{}, params instance_types.Properties) error {
 		sb.SetDistantFrom(params.DistantFrom)
 	}
 
+	// set additional disks
+	if sb, ok := sb.(serverAdditionalDiskParam); ok {
+		for i, d := range params.AdditionalDisks {
+			db := builder.Disk(client, fmt.Sprintf("%s-disk%d", params.Name, i+1))
+			db.SetPlan(d.Plan)
+			db.SetConnection(sacloud.EDiskConnection(d.Connection))
+			db.SetSize(d.Size)
+			db.SetDistantFrom(d.DistantFrom)
+			if d.SourceArchiveID > 0 {
+				db.SetSourceArchiveID(d.SourceArchiveID)
+			}
+			if d.SourceDiskID > 0 {
+				db.SetSourceDiskID(d.SourceDiskID)
+			}
+			sb.AddAdditionalDisk(db)
+		}
+	}
+
 	return nil
 }
 
-func handleServerCommonParams(sb interface{}, params instance_types.Properties) error {
+func handleServerCommonParams(client *api.Client, sb interface{}, params instance_types.Properties) error {
 	// set common params
 	var b serverBuilder
 	b, ok := sb.(serverBuilder)
@@ -224,14 +331,24 @@ func handleServerCommonParams(sb interface{}, params instance_types.Properties)
 	return nil
 }
 
-func handleDiskEvents(sb interface{}, params instance_types.Properties) error {
+func handleDiskEvents(sb interface{}, tracker *buildTracker, l *lifecycle) {
 	// set events
 	if diskEventBuilder, ok := sb.(serverDiskEventParam); ok {
+		// ssh keys and startup scripts are created before the disk
+		diskEventBuilder.SetDiskEventHandler(builder.DiskBuildOnCreateSSHKeyBefore, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
+			tracker.trackDisk(result)
+		})
+		diskEventBuilder.SetDiskEventHandler(builder.DiskBuildOnCreateNoteBefore, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
+			tracker.trackDisk(result)
+		})
+
 		diskEventBuilder.SetDiskEventHandler(builder.DiskBuildOnCreateDiskBefore, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
 			log.Debugln("CreateDisk:start")
+			tracker.trackDisk(result)
 		})
 		diskEventBuilder.SetDiskEventHandler(builder.DiskBuildOnCreateDiskAfter, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
 			log.Debugln("CreateDisk:finish")
+			l.notify(topicProvisionDiskCreated, 0)
 		})
 
 		// edit disk
@@ -240,6 +357,7 @@ func handleDiskEvents(sb interface{}, params instance_types.Properties) error {
 		})
 		diskEventBuilder.SetDiskEventHandler(builder.DiskBuildOnEditDiskAfter, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
 			log.Debugln("EditDisk:finish")
+			l.notify(topicProvisionDiskEdited, 0)
 		})
 
 		// cleanup startup script
@@ -248,6 +366,7 @@ func handleDiskEvents(sb interface{}, params instance_types.Properties) error {
 		})
 		diskEventBuilder.SetDiskEventHandler(builder.DiskBuildOnCleanupNoteAfter, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
 			log.Debugln("Cleanup StartupScript:finish")
+			tracker.cleanedNotes[result] = true
 		})
 
 		// cleanup ssh key script
@@ -256,19 +375,33 @@ func handleDiskEvents(sb interface{}, params instance_types.Properties) error {
 		})
 		diskEventBuilder.SetDiskEventHandler(builder.DiskBuildOnCleanupSSHKeyAfter, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
 			log.Debugln("Cleanup SSHKey:finish")
+			tracker.cleanedSSHKeys[result] = true
 		})
 	}
 
-	return nil
+	if sb, ok := sb.(serverAdditionalDiskParam); ok {
+		for _, db := range sb.GetAdditionalDisks() {
+			db.SetEventHandler(builder.DiskBuildOnCreateDiskBefore, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
+				log.Debugln("CreateAdditionalDisk:start")
+				tracker.trackDisk(result)
+			})
+			db.SetEventHandler(builder.DiskBuildOnCreateDiskAfter, func(value *builder.DiskBuildValue, result *builder.DiskBuildResult) {
+				log.Debugln("CreateAdditionalDisk:finish")
+				l.notify(topicProvisionDiskCreated, 0)
+			})
+		}
+	}
 }
 
-func handleServerEvents(sb interface{}, params instance_types.Properties) error {
+func handleServerEvents(sb interface{}, tracker *buildTracker, l *lifecycle) {
 	if serverEventBuilder, ok := sb.(serverEventparam); ok {
 		serverEventBuilder.SetEventHandler(builder.ServerBuildOnCreateServerBefore, func(value *builder.ServerBuildValue, result *builder.ServerBuildResult) {
 			log.Debugln("Create Server:start")
 		})
 		serverEventBuilder.SetEventHandler(builder.ServerBuildOnCreateServerAfter, func(value *builder.ServerBuildValue, result *builder.ServerBuildResult) {
 			log.Debugln("Create Server:finish")
+			tracker.trackServer(result)
+			l.notify(topicProvisionServerCreated, result.Server.ID)
 		})
 
 		serverEventBuilder.SetEventHandler(builder.ServerBuildOnBootBefore, func(value *builder.ServerBuildValue, result *builder.ServerBuildResult) {
@@ -276,10 +409,10 @@ func handleServerEvents(sb interface{}, params instance_types.Properties) error
 		})
 		serverEventBuilder.SetEventHandler(builder.ServerBuildOnBootAfter, func(value *builder.ServerBuildValue, result *builder.ServerBuildResult) {
 			log.Debugln("Boot Server:finish")
+			l.notify(topicProvisionBooted, result.Server.ID)
 		})
 
 	}
-	return nil
 }
 
 func validateServerDiskModeParams(params instance_types.Properties) []error {
@@ -325,11 +458,61 @@ func validateServerDiskModeParams(params instance_types.Properties) []error {
 		validateIfCtxIsSet("DiskMode", params.DiskMode, "DiskConnection", params.DiskConnection)
 		validateIfCtxIsSet("DiskSize", params.DiskMode, "DiskSize", params.DiskSize)
 		validateIfCtxIsSet("DiskSize", params.DiskMode, "OSType", params.OSType)
+		validateIfCtxIsSet("DiskMode", params.DiskMode, "AdditionalDisks", params.AdditionalDisks)
+	}
+
+	if len(params.AdditionalDisks)+1 > sacloud.ServerMaxDiskLen {
+		appendErrors([]error{fmt.Errorf("%q: up to %d disks can be connected to a server including the boot disk", "AdditionalDisks", sacloud.ServerMaxDiskLen)})
+	}
+	for i, d := range params.AdditionalDisks {
+		fieldName := fmt.Sprintf("AdditionalDisks[%d]", i)
+		appendErrors(validateInStrValues(fieldName+".Plan", d.Plan, "ssd", "hdd"))
+		appendErrors(validateInStrValues(fieldName+".Connection", d.Connection, "virtio", "ide"))
+		appendErrors(validateRequired(fieldName+".Size", d.Size))
+		appendErrors(validateSakuraID(fieldName+".SourceArchiveID", d.SourceArchiveID))
+		appendErrors(validateSakuraID(fieldName+".SourceDiskID", d.SourceDiskID))
+		appendErrors(validateConflicts(fieldName+".SourceArchiveID", d.SourceArchiveID, map[string]interface{}{
+			fieldName + ".SourceDiskID": d.SourceDiskID,
+		}))
 	}
 
 	return errs
 }
 
+func validateOSTypeParams(params instance_types.Properties) []error {
+	if params.OSType == "" {
+		return []error{}
+	}
+
+	errs := validateInStrValues("OSType", params.OSType, instance_types.OSTypes()...)
+	profile, ok := instance_types.OSProfileFor(params.OSType)
+	if !ok {
+		return errs
+	}
+
+	if params.DiskMode == "create" && params.DiskSize < profile.MinDiskSize {
+		errs = append(errs, fmt.Errorf("%q: %s requires at least %dGB disk", "DiskSize", params.OSType, profile.MinDiskSize))
+	}
+
+	values := map[string]interface{}{
+		"Password":             params.Password,
+		"DisablePasswordAuth":  params.DisablePasswordAuth,
+		"StartupScripts":       params.StartupScripts,
+		"StartupScriptFiles":   params.StartupScriptFiles,
+		"StartupScriptIDs":     params.StartupScriptIDs,
+		"SSHKeyIDs":            params.SSHKeyIDs,
+		"SSHKeyPublicKeys":     params.SSHKeyPublicKeys,
+		"SSHKeyPublicKeyFiles": params.SSHKeyPublicKeyFiles,
+		"GenerateSSHKey":       params.GenerateSSHKey,
+	}
+	for _, property := range profile.Unsupported {
+		if !isEmpty(values[property]) {
+			errs = append(errs, fmt.Errorf("%q: is not supported on %s", property, params.OSType))
+		}
+	}
+	return errs
+}
+
 func validateServerNetworkParams(sb interface{}, params instance_types.Properties) []error {
 	var errs []error
 	var appendErrors = func(e []error) {
@@ -348,6 +531,10 @@ func validateServerNetworkParams(sb interface{}, params instance_types.Propertie
 		}
 	}
 
+	if len(params.Networks) > 0 {
+		return validateServerNetworksParams(sb, params)
+	}
+
 	if sb, ok := sb.(serverNetworkParams); ok {
 		switch params.NetworkMode {
 		case "shared", "disconnect", "none":
@@ -408,10 +595,12 @@ func validateServerDiskEditParams(sb interface{}, params instance_types.Properti
 		validateProhibitedIfCtxIsSet("StartupScriptIDs", params.StartupScriptIDs)
 		validateProhibitedIfCtxIsSet("StartupScripts", params.StartupScripts)
 		validateProhibitedIfCtxIsSet("StartupScriptsEphemeral", params.StartupScriptsEphemeral)
+		validateProhibitedIfCtxIsSet("StartupScriptFiles", params.StartupScriptFiles)
 		validateProhibitedIfCtxIsSet("SSHKeyIDs", params.SSHKeyIDs)
 		validateProhibitedIfCtxIsSet("SSHKeyPublicKeys", params.SSHKeyPublicKeys)
 		validateProhibitedIfCtxIsSet("SSHKeyPublicKeyFiles", params.SSHKeyPublicKeyFiles)
 		validateProhibitedIfCtxIsSet("SSHKeyEphemeral", params.SSHKeyEphemeral)
+		validateProhibitedIfCtxIsSet("GenerateSSHKey", params.GenerateSSHKey)
 	}
 
 	return errs
@@ -479,6 +668,11 @@ type serverDiskEventParam interface {
 	SetDiskEventHandler(event builder.DiskBuildEvents, handler builder.DiskBuildEventHandler)
 }
 
+type serverAdditionalDiskParam interface {
+	AddAdditionalDisk(diskBuilder *builder.DiskBuilder)
+	GetAdditionalDisks() []*builder.DiskBuilder
+}
+
 type serverEventparam interface {
 	SetEventHandler(event builder.ServerBuildEvents, handler builder.ServerBuildEventHandler)
 }
@@ -491,5 +685,5 @@ func flattenErrors(errors []error) error {
 	for _, str := range errors {
 		list = append(list, str.Error())
 	}
-	return fmt.Errorf(strings.Join(list, "\n"))
+	return fmt.Errorf("%s", strings.Join(list, "\n"))
 }
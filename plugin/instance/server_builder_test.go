@@ -0,0 +1,90 @@
+package instance
+
+import (
+	"testing"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/builder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAdditionalDisks(t *testing.T) {
+	params := instance_types.Properties{
+		DiskMode:       "create",
+		DiskPlan:       "ssd",
+		DiskConnection: "virtio",
+		DiskSize:       20,
+		AdditionalDisks: []instance_types.AdditionalDisk{
+			{Plan: "hdd", Connection: "virtio", Size: 100},
+			{Plan: "ssd", Connection: "ide", Size: 20, SourceArchiveID: 112233445566},
+			{Plan: "ssd", Connection: "virtio", Size: 20},
+		},
+	}
+	assert.Empty(t, validateServerDiskModeParams(params))
+
+	// exceeds the limit with the boot disk
+	params.AdditionalDisks = append(params.AdditionalDisks, instance_types.AdditionalDisk{Plan: "ssd", Connection: "virtio", Size: 20})
+	assert.Len(t, validateServerDiskModeParams(params), 1)
+
+	params.AdditionalDisks = []instance_types.AdditionalDisk{
+		{Plan: "nvme", Connection: "virtio", Size: 20, SourceArchiveID: 112233445566, SourceDiskID: 112233445567},
+	}
+	assert.Len(t, validateServerDiskModeParams(params), 2)
+}
+
+func TestValidateOSTypeParams(t *testing.T) {
+	params := instance_types.Properties{
+		DiskMode:         "create",
+		OSType:           "windows2016-rds",
+		DiskSize:         40,
+		Password:         "password",
+		StartupScripts:   []string{"echo hello"},
+		SSHKeyPublicKeys: []string{"ssh-rsa AAAA..."},
+	}
+	errs := validateOSTypeParams(params)
+	assert.Len(t, errs, 3)
+	assert.EqualError(t, errs[0], `"DiskSize": windows2016-rds requires at least 100GB disk`)
+	assert.EqualError(t, errs[1], `"SSHKeyPublicKeys": is not supported on windows2016-rds`)
+	assert.EqualError(t, errs[2], `"StartupScripts": is not supported on windows2016-rds`)
+
+	params.OSType = "coreos"
+	errs = validateOSTypeParams(params)
+	assert.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], `"Password": is not supported on coreos`)
+
+	params.OSType = "windows2003"
+	assert.Len(t, validateOSTypeParams(params), 1)
+}
+
+func TestHandleDiskEditParamsGenerateSSHKey(t *testing.T) {
+	client := api.NewClient("token", "secret", "tk1a")
+
+	// GenerateSSHKey alone runs the disk edit with the server name as the hostname
+	params := instance_types.Properties{
+		Name:            "etcd-abcdef",
+		DiskMode:        "create",
+		SourceArchiveID: 112233445566,
+		GenerateSSHKey:  true,
+	}
+	sb := createServerBuilder(client, params)
+	assert.NoError(t, handleDiskEditParams(client, sb, params))
+	assert.Equal(t, "etcd-abcdef", sb.(*builder.CommonServerBuilder).GetHostName())
+	assert.Equal(t, "etcd-abcdef", sb.(*builder.CommonServerBuilder).GetGenerateSSHKeyName())
+
+	params.Hostname = "etcd-1"
+	sb = createServerBuilder(client, params)
+	assert.NoError(t, handleDiskEditParams(client, sb, params))
+	assert.Equal(t, "etcd-1", sb.(*builder.CommonServerBuilder).GetHostName())
+
+	// disks which can't be edited
+	for _, params := range []instance_types.Properties{
+		{Name: "etcd-abcdef", DiskMode: "create", GenerateSSHKey: true},
+		{Name: "etcd-abcdef", DiskMode: "connect", DiskID: 112233445566, GenerateSSHKey: true},
+		{Name: "etcd-abcdef", DiskMode: "diskless", GenerateSSHKey: true},
+	} {
+		errs := validateServerDiskEditParams(createServerBuilder(client, params), params)
+		assert.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), `"GenerateSSHKey"`)
+	}
+}
@@ -0,0 +1,43 @@
+package instance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/sacloud/libsacloud/builder"
+)
+
+// privateKeyPath returns the path of the private key generated for the instance
+func privateKeyPath(keyDir string, id instance.ID) string {
+	return filepath.Join(keyDir, strings.Replace(string(id), "/", "-", -1))
+}
+
+// writePrivateKey writes the private key generated by the build into the key directory
+func writePrivateKey(keyDir string, id instance.ID, res *builder.ServerBuildResult) error {
+	for _, d := range res.Disks {
+		if d == nil || d.GeneratedSSHKey == nil {
+			continue
+		}
+		if err := os.MkdirAll(keyDir, 0700); err != nil {
+			return fmt.Errorf("Writing private key is failed: %s", err)
+		}
+		if err := ioutil.WriteFile(privateKeyPath(keyDir, id), []byte(d.GeneratedSSHKey.PrivateKey), 0600); err != nil {
+			return fmt.Errorf("Writing private key is failed: %s", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("Writing private key is failed: no SSH key is generated")
+}
+
+// removePrivateKey removes the private key of the instance if it exists
+func removePrivateKey(keyDir string, id instance.ID) error {
+	err := os.Remove(privateKeyPath(keyDir, id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
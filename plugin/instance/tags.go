@@ -0,0 +1,90 @@
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/sacloud"
+)
+
+// instanceTags returns infrakit tags of the server.
+//
+// Since version 2 of the plugin, infrakit tags are stored in the SakuraCloud tags(see instance_types.EncodeTags),
+// and user-defined plain tags are also reported. Older servers keep them in the Description as "key:value" lines.
+func instanceTags(server *sacloud.Server) map[string]string {
+	if isV1Server(server) {
+		return infrakitTags(server)
+	}
+
+	tags := map[string]string{}
+	for _, tag := range server.Tags {
+		if instance_types.IsReservedTag(tag) || strings.HasPrefix(tag, "@") {
+			continue
+		}
+		_, tags = mergeTags(tags, sliceToMap([]string{tag}))
+	}
+	_, tags = mergeTags(tags, infrakitTags(server))
+	return tags
+}
+
+// infrakitTags returns infrakit tags of the server, without user-defined plain tags
+func infrakitTags(server *sacloud.Server) map[string]string {
+	if isV1Server(server) {
+		return sliceToMap(undoTags(server.Description))
+	}
+	return instance_types.DecodeTags(server.Tags)
+}
+
+// applyLabels merges labels into the current tags. A label with an empty value deletes the key.
+// Keys prefixed with "infrakit-" and the namespace tags are protected and can't be changed.
+func applyLabels(current, labels, namespace map[string]string) (map[string]string, error) {
+	_, tags := mergeTags(current)
+
+	errs := []error{}
+	for k, v := range labels {
+		if _, ok := namespace[k]; ok || strings.HasPrefix(k, "infrakit-") {
+			if current[k] != v {
+				errs = append(errs, fmt.Errorf("%q: is protected and can't be changed by labels", k))
+			}
+			continue
+		}
+
+		if v == "" {
+			delete(tags, k)
+		} else {
+			tags[k] = v
+		}
+	}
+	if len(errs) > 0 {
+		return nil, flattenErrors(errs)
+	}
+	return tags, nil
+}
+
+// setInstanceTags replaces infrakit tags of the server with given tags.
+// User-defined plain tags are kept as is. The Description of servers created by older versions is cleared
+// since it holds nothing but infrakit tags.
+func setInstanceTags(server *sacloud.Server, tags map[string]string) {
+	if isV1Server(server) {
+		server.Description = ""
+	}
+
+	newTags := []string{}
+	for _, tag := range server.Tags {
+		if !instance_types.IsReservedTag(tag) {
+			newTags = append(newTags, tag)
+		}
+	}
+	server.Tags = append(newTags, instance_types.EncodeTags(tags)...)
+}
+
+// isV1Server returns true if the server keeps infrakit tags in its Description
+func isV1Server(server *sacloud.Server) bool {
+	for _, tag := range server.Tags {
+		if instance_types.IsReservedTag(tag) {
+			return false
+		}
+	}
+	return strings.Contains(server.Description, v1VersionSearchTerm)
+}
@@ -0,0 +1,54 @@
+package instance
+
+import (
+	"testing"
+
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyLabels(t *testing.T) {
+	current := map[string]string{
+		instance_types.InfrakitLogicalID:          "logical-01",
+		instance_types.InfrakitSakuraCloudVersion: instance_types.InfrakitSakuraCloudCurrentVersion,
+		"cluster":             "prod",
+		"infrakit.config_sha": "old",
+		"foo":                 "bar",
+	}
+	namespace := map[string]string{"cluster": "prod"}
+
+	tags, err := applyLabels(current, map[string]string{
+		"infrakit.config_sha": "new",
+		"foo":                 "",
+		"new":                 "value",
+		"cluster":             "prod",
+	}, namespace)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		instance_types.InfrakitLogicalID:          "logical-01",
+		instance_types.InfrakitSakuraCloudVersion: instance_types.InfrakitSakuraCloudCurrentVersion,
+		"cluster":             "prod",
+		"infrakit.config_sha": "new",
+		"new":                 "value",
+	}, tags)
+
+	// current tags must not be modified
+	assert.Equal(t, "bar", current["foo"])
+}
+
+func TestApplyLabelsProtected(t *testing.T) {
+	current := map[string]string{
+		instance_types.InfrakitLogicalID: "logical-01",
+		"cluster":                        "prod",
+	}
+	namespace := map[string]string{"cluster": "prod"}
+
+	_, err := applyLabels(current, map[string]string{instance_types.InfrakitLogicalID: "logical-02"}, namespace)
+	assert.Error(t, err)
+
+	_, err = applyLabels(current, map[string]string{instance_types.InfrakitLogicalID: ""}, namespace)
+	assert.Error(t, err)
+
+	_, err = applyLabels(current, map[string]string{"cluster": "dev"}, namespace)
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package types
+
+import (
+	"time"
+)
+
+// Description is the schema of instance.Description.Properties reported by DescribeInstances
+type Description struct {
+	Name string
+	Zone string
+
+	// Status is the status of the server, such as "up" or "down"
+	Status       string
+	Availability string
+
+	Core   int
+	Memory int
+
+	// Networks are NICs in order from eth0
+	Networks []NetworkDescription
+
+	// Disks are disks in order of connection
+	Disks []DiskDescription
+
+	// SourceArchiveID is the archive the boot disk was created from, 0 if unknown
+	SourceArchiveID int64
+
+	CreatedAt *time.Time `json:",omitempty"`
+}
+
+// NetworkDescription is a NIC of the server
+type NetworkDescription struct {
+	// Type is one of "shared", "switch" or "disconnect"
+	Type           string
+	SwitchID       int64  `json:",omitempty"`
+	IPAddress      string `json:",omitempty"`
+	MACAddress     string
+	PacketFilterID int64 `json:",omitempty"`
+}
+
+// DiskDescription is a disk connected to the server
+type DiskDescription struct {
+	ID   int64
+	Name string
+
+	// Plan is "ssd" or "hdd", empty if unknown
+	Plan       string `json:",omitempty"`
+	Connection string `json:",omitempty"`
+
+	// Size is the size in GB
+	Size int
+}
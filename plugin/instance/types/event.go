@@ -0,0 +1,21 @@
+package types
+
+import (
+	"time"
+)
+
+// LifecycleEvent is the schema of the data of events published on provision and destroy
+type LifecycleEvent struct {
+	// ID is the instance ID, empty until the server is created
+	ID        string `json:",omitempty"`
+	LogicalID string `json:",omitempty"`
+	Zone      string `json:",omitempty"`
+
+	// StartedAt is the time the provision or destroy started
+	StartedAt time.Time
+
+	// Elapsed is the time since StartedAt, such as "1m30s"
+	Elapsed string
+
+	Error string `json:",omitempty"`
+}
@@ -0,0 +1,85 @@
+package types
+
+import (
+	"github.com/sacloud/libsacloud/sacloud/ostype"
+)
+
+const (
+	// defaultDiskSize is the size(GB) of the boot disk when neither DiskSize nor the OS profile gives it
+	defaultDiskSize = 20
+
+	// defaultDiskConnection is the connection of the boot disk when neither DiskConnection nor the OS profile gives it
+	defaultDiskConnection = "virtio"
+)
+
+// OSProfile is the OS specific behavior of a public archive
+type OSProfile struct {
+	// MinDiskSize is the minimum size(GB) of the boot disk, also used as the default DiskSize
+	MinDiskSize int
+
+	// DiskConnection is the default DiskConnection
+	DiskConnection string
+
+	// Unsupported are names of Properties which can't be used with the OS
+	Unsupported []string
+}
+
+var (
+	sshKeyProperties        = []string{"SSHKeyIDs", "SSHKeyPublicKeys", "SSHKeyPublicKeyFiles", "GenerateSSHKey"}
+	startupScriptProperties = []string{"StartupScripts", "StartupScriptFiles", "StartupScriptIDs"}
+	passwordProperties      = []string{"Password", "DisablePasswordAuth"}
+
+	unixProfile     = OSProfile{MinDiskSize: 20, DiskConnection: "virtio"}
+	noPasswdProfile = OSProfile{MinDiskSize: 20, DiskConnection: "virtio", Unsupported: passwordProperties}
+	windowsProfile  = OSProfile{
+		MinDiskSize:    100,
+		DiskConnection: "virtio",
+		Unsupported:    append(append([]string{}, sshKeyProperties...), startupScriptProperties...),
+	}
+)
+
+// osProfiles are profiles for all of the OSType values
+var osProfiles = map[ostype.ArchiveOSTypes]OSProfile{
+	ostype.CentOS:                       unixProfile,
+	ostype.Ubuntu:                       unixProfile,
+	ostype.Debian:                       unixProfile,
+	ostype.VyOS:                         unixProfile,
+	ostype.CoreOS:                       noPasswdProfile,
+	ostype.RancherOS:                    noPasswdProfile,
+	ostype.Kusanagi:                     unixProfile,
+	ostype.SiteGuard:                    unixProfile,
+	ostype.Plesk:                        unixProfile,
+	ostype.FreeBSD:                      unixProfile,
+	ostype.Windows2012:                  windowsProfile,
+	ostype.Windows2012RDS:               windowsProfile,
+	ostype.Windows2012RDSOffice:         windowsProfile,
+	ostype.Windows2016:                  windowsProfile,
+	ostype.Windows2016RDS:               windowsProfile,
+	ostype.Windows2016RDSOffice:         windowsProfile,
+	ostype.Windows2016SQLServerWeb:      windowsProfile,
+	ostype.Windows2016SQLServerStandard: windowsProfile,
+}
+
+// OSTypes returns all of the available OSType values
+func OSTypes() []string {
+	return append([]string{}, ostype.OSTypeShortNames...)
+}
+
+// OSProfileFor returns the profile of the OSType value. The second return value is false for unknown values.
+func OSProfileFor(osType string) (OSProfile, bool) {
+	profile, ok := osProfiles[ostype.StrToOSType(osType)]
+	return profile, ok
+}
+
+func (p *Properties) setOSDefaults() {
+	diskSize, diskConnection := defaultDiskSize, defaultDiskConnection
+	if profile, ok := OSProfileFor(p.OSType); ok {
+		diskSize, diskConnection = profile.MinDiskSize, profile.DiskConnection
+	}
+	if p.DiskSize == 0 {
+		p.DiskSize = diskSize
+	}
+	if p.DiskConnection == "" {
+		p.DiskConnection = diskConnection
+	}
+}
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/docker/infrakit/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOSProfiles(t *testing.T) {
+	for _, osType := range OSTypes() {
+		_, ok := OSProfileFor(osType)
+		assert.True(t, ok, osType)
+	}
+	_, ok := OSProfileFor("windows2003")
+	assert.False(t, ok)
+}
+
+func TestParsePropertiesOSDefaults(t *testing.T) {
+	properties := types.AnyString(`{
+	  "NamePrefix": "win",
+	  "OSType": "ubuntu",
+	  "LogicalIDOverrides": {
+	    "win-1": {"OSType": "windows2016-sql-web"},
+	    "win-2": {"OSType": "windows2016", "DiskSize": 250, "DiskConnection": "ide"}
+	  }
+	}`)
+
+	parsed, err := ParseProperties(properties)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, parsed.DiskSize)
+	assert.Equal(t, "virtio", parsed.DiskConnection)
+
+	parsed, err = ParsePropertiesFor(properties, "win-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, parsed.DiskSize)
+	assert.Equal(t, "virtio", parsed.DiskConnection)
+
+	parsed, err = ParsePropertiesFor(properties, "win-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 250, parsed.DiskSize)
+	assert.Equal(t, "ide", parsed.DiskConnection)
+
+	// the boot disk isn't created
+	parsed, err = ParseProperties(types.AnyString(`{"DiskMode": "connect", "DiskID": 112233445566}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, parsed.DiskSize)
+}
@@ -0,0 +1,147 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// TagPrefix is the prefix of SakuraCloud tags that hold an infrakit tag, formatted as "ik:key=value".
+	TagPrefix = "ik:"
+
+	// ChunkedTagPrefix is the prefix of SakuraCloud tags that hold a part of an infrakit tag which is too long
+	// for a single tag, formatted as "ik#<tag index>.<chunk index>:part".
+	ChunkedTagPrefix = "ik#"
+
+	// MaxTagLength is the maximum length of a SakuraCloud tag
+	MaxTagLength = 32
+)
+
+// tagKeyAliases shortens well-known keys so that their tags fit in MaxTagLength.
+// Aliases start with "." so that they never collide with user-defined keys.
+var tagKeyAliases = map[string]string{
+	InfrakitSakuraCloudVersion: ".v",
+	InfrakitLogicalID:          ".lid",
+	InfrakitDestroyPolicy:      ".dp",
+	InfrakitDestroyed:          ".del",
+	InfrakitCreatedDisks:       ".disks",
+	InfrakitAttachments:        ".att",
+	InfrakitSourceArchive:      ".arc",
+	InfrakitStartupScriptHash:  ".nh",
+	InfrakitGeneratedSSHKey:    ".gk",
+	"infrakit.group":           ".grp",
+	"infrakit.config_sha":      ".sha",
+}
+
+// IsReservedTag returns true if the SakuraCloud tag holds (a part of) an infrakit tag.
+func IsReservedTag(tag string) bool {
+	return strings.HasPrefix(tag, TagPrefix) || strings.HasPrefix(tag, ChunkedTagPrefix)
+}
+
+// EncodeTag converts an infrakit tag into a SakuraCloud tag.
+// The second return value is false if the tag doesn't fit in a single SakuraCloud tag.
+func EncodeTag(key, value string) (string, bool) {
+	tag := TagPrefix + tagPayload(key, value)
+	return tag, len(tag) <= MaxTagLength
+}
+
+// EncodeTags converts infrakit tags into SakuraCloud tags.
+// Tags exceeding MaxTagLength bytes are split into multiple chunked tags on rune boundaries.
+func EncodeTags(tags map[string]string) []string {
+	keys := []string{}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	encoded := []string{}
+	for i, k := range keys {
+		if tag, ok := EncodeTag(k, tags[k]); ok {
+			encoded = append(encoded, tag)
+			continue
+		}
+
+		payload := tagPayload(k, tags[k])
+		for j := 0; len(payload) > 0; j++ {
+			header := fmt.Sprintf("%s%d.%d:", ChunkedTagPrefix, i, j)
+			size := MaxTagLength - len(header)
+			if size >= len(payload) {
+				size = len(payload)
+			} else {
+				// split on a rune boundary, chunks must be valid UTF-8
+				for size > 0 && !utf8.RuneStart(payload[size]) {
+					size--
+				}
+			}
+			encoded = append(encoded, header+payload[:size])
+			payload = payload[size:]
+		}
+	}
+	return encoded
+}
+
+// DecodeTags extracts infrakit tags from SakuraCloud tags. Non-reserved tags are ignored.
+func DecodeTags(tags []string) map[string]string {
+	decoded := map[string]string{}
+	chunks := map[int]map[int]string{}
+
+	for _, tag := range tags {
+		switch {
+		case strings.HasPrefix(tag, TagPrefix):
+			k, v := parseTagPayload(strings.TrimPrefix(tag, TagPrefix))
+			decoded[k] = v
+		case strings.HasPrefix(tag, ChunkedTagPrefix):
+			parts := strings.SplitN(strings.TrimPrefix(tag, ChunkedTagPrefix), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			indexes := strings.SplitN(parts[0], ".", 2)
+			if len(indexes) != 2 {
+				continue
+			}
+			i, err1 := strconv.Atoi(indexes[0])
+			j, err2 := strconv.Atoi(indexes[1])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			if _, ok := chunks[i]; !ok {
+				chunks[i] = map[int]string{}
+			}
+			chunks[i][j] = parts[1]
+		}
+	}
+
+	for _, parts := range chunks {
+		payload := ""
+		for j := 0; j < len(parts); j++ {
+			payload += parts[j]
+		}
+		k, v := parseTagPayload(payload)
+		decoded[k] = v
+	}
+	return decoded
+}
+
+func tagPayload(key, value string) string {
+	if alias, ok := tagKeyAliases[key]; ok {
+		key = alias
+	}
+	return key + "=" + value
+}
+
+func parseTagPayload(payload string) (string, string) {
+	parts := strings.SplitN(payload, "=", 2)
+	key, value := parts[0], ""
+	if len(parts) == 2 {
+		value = parts[1]
+	}
+	for k, alias := range tagKeyAliases {
+		if key == alias {
+			return k, value
+		}
+	}
+	return key, value
+}
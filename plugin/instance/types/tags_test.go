@@ -0,0 +1,81 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeTags(t *testing.T) {
+	tags := EncodeTags(map[string]string{
+		"foo":                      "bar",
+		"banana":                   "",
+		InfrakitSakuraCloudVersion: InfrakitSakuraCloudCurrentVersion,
+	})
+
+	assert.Equal(t, []string{
+		"ik:banana=",
+		"ik:foo=bar",
+		"ik:.v=" + InfrakitSakuraCloudCurrentVersion,
+	}, tags)
+}
+
+func TestEncodeTagsLongValue(t *testing.T) {
+	value := strings.Repeat("0123456789", 6)
+	tags := EncodeTags(map[string]string{
+		"infrakit.config_sha": value,
+	})
+
+	assert.True(t, len(tags) > 1)
+	for _, tag := range tags {
+		assert.True(t, len(tag) <= MaxTagLength, tag)
+		assert.True(t, strings.HasPrefix(tag, ChunkedTagPrefix), tag)
+		assert.True(t, IsReservedTag(tag))
+	}
+
+	assert.Equal(t, map[string]string{"infrakit.config_sha": value}, DecodeTags(tags))
+}
+
+func TestEncodeTagsLongMultibyteValue(t *testing.T) {
+	value := "山田太郎のサーバーグループ"
+	tags := EncodeTags(map[string]string{"owner": value})
+
+	assert.True(t, len(tags) > 1)
+	for _, tag := range tags {
+		assert.True(t, len(tag) <= MaxTagLength, tag)
+		assert.True(t, utf8.ValidString(tag), tag)
+	}
+
+	// tags are sent to the API as JSON
+	b, err := json.Marshal(tags)
+	assert.NoError(t, err)
+	decoded := []string{}
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+
+	assert.Equal(t, map[string]string{"owner": value}, DecodeTags(decoded))
+}
+
+func TestDecodeTags(t *testing.T) {
+	long := strings.Repeat("x", 80)
+	tags := EncodeTags(map[string]string{
+		"foo":             "bar",
+		"banana":          "",
+		InfrakitLogicalID: "logical-01",
+		"long":            long,
+	})
+	// order of tags is not guaranteed by the API
+	reversed := []string{"@virtio-net-pci", "user-tag"}
+	for i := len(tags) - 1; i >= 0; i-- {
+		reversed = append(reversed, tags[i])
+	}
+
+	assert.Equal(t, map[string]string{
+		"foo":             "bar",
+		"banana":          "",
+		InfrakitLogicalID: "logical-01",
+		"long":            long,
+	}, DecodeTags(reversed))
+}
@@ -1,6 +1,8 @@
 package types
 
 import (
+	"strings"
+
 	"github.com/docker/infrakit/pkg/spi/instance"
 	"github.com/docker/infrakit/pkg/types"
 	"github.com/pkg/errors"
@@ -14,14 +16,155 @@ const (
 	// the instance.
 	InfrakitSakuraCloudVersion = "infrakit-sakuracloud-version"
 
+	// InfrakitDestroyPolicy is a metadata key that is used to know what to do with the instance on Destroy.
+	InfrakitDestroyPolicy = "infrakit-destroy-policy"
+
+	// InfrakitDestroyed is a metadata key that is used to mark instances powered off by Destroy, but not deleted.
+	InfrakitDestroyed = "infrakit-destroyed"
+
+	// InfrakitOrphanedFrom is a metadata key that is used to tag disks kept on Destroy with the instance ID.
+	InfrakitOrphanedFrom = "infrakit-orphaned-from"
+
+	// InfrakitCreatedDisks is a metadata key that is used to know which disks of the instance were created by the plugin.
+	// Its value is a comma-separated list of disk IDs.
+	InfrakitCreatedDisks = "infrakit-created-disks"
+
+	// InfrakitAttachments is a metadata key that is used to know which resources were attached to the instance from
+	// instance.Spec.Attachments.
+	InfrakitAttachments = "infrakit-attachments"
+
+	// InfrakitSourceArchive is a metadata key that is used to know which archive the boot disk of the instance was
+	// created from.
+	InfrakitSourceArchive = "infrakit-source-archive"
+
+	// InfrakitStartupScriptHash is a metadata key that is used to tag startup scripts reused between instances with
+	// the hash of their content.
+	InfrakitStartupScriptHash = "infrakit-startup-script-hash"
+
+	// InfrakitGeneratedSSHKey is a metadata key that is used to mark instances with a SSH keypair generated by the
+	// plugin, whose private key is stored in the key directory.
+	InfrakitGeneratedSSHKey = "infrakit-generated-ssh-key"
+
+	// InfrakitSSHKeyFile is a metadata key that is used to report the location of the generated private key on
+	// describe. It is not stored in the instance.
+	InfrakitSSHKeyFile = "infrakit-ssh-key-file"
+
+	// InfrakitHealth is a metadata key that is used to report why the instance is unhealthy on describe.
+	// It is not stored in the instance.
+	InfrakitHealth = "infrakit-health"
+
+	// InfrakitArchivedFrom is a metadata key that is used to tag archives created on Destroy with the instance ID.
+	InfrakitArchivedFrom = "infrakit-archived-from"
+
 	// InfrakitSakuraCloudCurrentVersion is incremented each time the plugin introduces incompatibilities with previous
 	// versions
-	InfrakitSakuraCloudCurrentVersion = "1"
+	InfrakitSakuraCloudCurrentVersion = "2"
+)
+
+const (
+	// DestroyModeDelete deletes the server with its disks
+	DestroyModeDelete = "delete"
+
+	// DestroyModeKeep deletes the server, and keeps its disks tagged as orphaned
+	DestroyModeKeep = "keep"
+
+	// DestroyModeArchive creates an archive from the boot disk, then deletes the server with its disks
+	DestroyModeArchive = "archive"
+
+	// DestroyModePowerOff only stops the server
+	DestroyModePowerOff = "poweroff"
 )
 
+// DestroyModes are all of the available modes of DestroyPolicy
+var DestroyModes = []string{DestroyModeDelete, DestroyModeKeep, DestroyModeArchive, DestroyModePowerOff}
+
+// DestroyPolicy is the configuration of what to do with the server and its disks on Destroy
+type DestroyPolicy struct {
+	// Mode is used when no mode is given for the reason of Destroy
+	Mode string
+
+	// RollingUpdate is the mode used on Destroy with instance.RollingUpdate
+	RollingUpdate string
+
+	// Termination is the mode used on Destroy with instance.Termination
+	Termination string
+}
+
+// ModeFor returns the mode for the reason of Destroy(instance.Context.Reason)
+func (d DestroyPolicy) ModeFor(reason string) string {
+	mode := d.Mode
+	switch reason {
+	case instance.RollingUpdate.Reason:
+		if d.RollingUpdate != "" {
+			mode = d.RollingUpdate
+		}
+	case instance.Termination.Reason:
+		if d.Termination != "" {
+			mode = d.Termination
+		}
+	}
+	if mode == "" {
+		mode = DestroyModeDelete
+	}
+	return mode
+}
+
+// String returns the compact form of the policy to be stored in the instance tags
+func (d DestroyPolicy) String() string {
+	return strings.Join([]string{d.Mode, d.RollingUpdate, d.Termination}, "/")
+}
+
+// ParseDestroyPolicy parses the form returned by DestroyPolicy.String
+func ParseDestroyPolicy(s string) DestroyPolicy {
+	parts := strings.SplitN(s, "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return DestroyPolicy{
+		Mode:          parts[0],
+		RollingUpdate: parts[1],
+		Termination:   parts[2],
+	}
+}
+
+// AdditionalDisk is the configuration of a data disk created with the server
+type AdditionalDisk struct {
+	Plan            string
+	Connection      string
+	Size            int
+	SourceArchiveID int64
+	SourceDiskID    int64
+	DistantFrom     []int64
+}
+
+// ArchiveSelector selects the source archive by its name and tags, instead of the ID
+type ArchiveSelector struct {
+	// Name is a glob pattern(e.g. "web-*") matched with the whole name of the archive
+	Name string
+
+	// Tags are tags which the archive must have all of
+	Tags []string
+
+	// Latest selects the most recently created archive when multiple archives match
+	Latest bool
+}
+
+// Network is the configuration of a NIC
+type Network struct {
+	// Type is one of "shared", "switch" or "disconnect"
+	Type           string
+	SwitchID       int64
+	IPAddress      string
+	NwMasklen      int
+	DefaultRoute   string
+	PacketFilterID int64
+}
+
 // Properties is the configuration schema for the plugin, provided in instance.Spec.Properties
 type Properties struct {
 	NamePrefix      string
+	Zones           []string
+	ZoneStrategy    string
 	Core            int
 	Memory          int
 	DiskMode        string
@@ -32,9 +175,14 @@ type Properties struct {
 	SourceArchiveID int64
 	SourceDiskID    int64
 
+	// SourceArchive is resolved into SourceArchiveID on Provision
+	SourceArchive *ArchiveSelector
+
 	DistantFrom []int64
 	DiskID      int64
 
+	AdditionalDisks []AdditionalDisk
+
 	ISOImageID     int64
 	UseNicVirtIO   bool
 	PacketFilterID int64
@@ -49,35 +197,78 @@ type Properties struct {
 	NwMasklen    int
 	DefaultRoute string
 
+	// Networks are NICs in order from eth0. NetworkMode and its parameters are the shorthand for a single NIC.
+	Networks []Network
+
 	StartupScripts          []string
+	StartupScriptFiles      []string
 	StartupScriptIDs        []int64
 	StartupScriptsEphemeral bool
 
+	// DisableScriptTemplates passes StartupScripts and the Init of the spec as they are, without rendering them as templates
+	DisableScriptTemplates bool
+
 	SSHKeyIDs            []int64
 	SSHKeyPublicKeys     []string
 	SSHKeyPublicKeyFiles []string
 	SSHKeyEphemeral      bool
 
+	// GenerateSSHKey generates a SSH keypair on the build, and stores the private key in the key directory
+	GenerateSSHKey            bool
+	GenerateSSHKeyPassPhrase  string
+	GenerateSSHKeyDescription string
+
 	Name        string
 	Description string
 	Tags        []string
 	IconID      int64
 	UsKeyboard  bool
+
+	DestroyPolicy DestroyPolicy
+
+	// LogicalIDOverrides are partial Properties merged into the Properties of the instance with the LogicalID
+	LogicalIDOverrides map[string]*types.Any
 }
 
 // ParseProperties parses instance Properties from a json description.
 func ParseProperties(req *types.Any) (Properties, error) {
+	parsed, err := parseProperties(req)
+	if err != nil {
+		return parsed, err
+	}
+	parsed.setDefaults()
+	return parsed, nil
+}
+
+// ParsePropertiesFor parses instance Properties for the logical ID, merging LogicalIDOverrides[logicalID] into them.
+func ParsePropertiesFor(req *types.Any, logicalID string) (Properties, error) {
+	parsed, err := parseProperties(req)
+	if err != nil {
+		return parsed, err
+	}
+
+	if override := parsed.LogicalIDOverrides[logicalID]; override != nil {
+		if err := override.Decode(&parsed); err != nil {
+			return parsed, errors.Wrapf(err, "invalid properties for logical ID %s", logicalID)
+		}
+	}
+	parsed.setDefaults()
+	return parsed, nil
+}
+
+// parseProperties parses instance Properties without defaults depending on other properties
+func parseProperties(req *types.Any) (Properties, error) {
 	parsed := Properties{
+		ZoneStrategy:            "round-robin",
 		Core:                    1,
 		Memory:                  1,
 		DiskMode:                "create",
 		DiskPlan:                "ssd",
-		DiskConnection:          "virtio",
-		DiskSize:                20,
 		NetworkMode:             "shared",
 		UseNicVirtIO:            true,
 		StartupScriptsEphemeral: true,
 		SSHKeyEphemeral:         true,
+		DestroyPolicy:           DestroyPolicy{Mode: DestroyModeDelete},
 	}
 
 	if err := req.Decode(&parsed); err != nil {
@@ -86,6 +277,46 @@ func ParseProperties(req *types.Any) (Properties, error) {
 	return parsed, nil
 }
 
+func (p *Properties) setDefaults() {
+	if p.DiskMode == "create" {
+		p.setOSDefaults()
+	}
+	p.setAdditionalDiskDefaults()
+}
+
+func (p *Properties) setAdditionalDiskDefaults() {
+	for i := range p.AdditionalDisks {
+		d := &p.AdditionalDisks[i]
+		if d.Plan == "" {
+			d.Plan = "ssd"
+		}
+		if d.Connection == "" {
+			d.Connection = "virtio"
+		}
+		if d.Size == 0 {
+			d.Size = 20
+		}
+	}
+}
+
+// NetworkInterfaces returns Networks, or the single NIC described by NetworkMode and its parameters
+func (p Properties) NetworkInterfaces() []Network {
+	if len(p.Networks) > 0 {
+		return p.Networks
+	}
+	if p.NetworkMode == "none" {
+		return []Network{}
+	}
+	return []Network{{
+		Type:           p.NetworkMode,
+		SwitchID:       p.SwitchID,
+		IPAddress:      p.IPAddress,
+		NwMasklen:      p.NwMasklen,
+		DefaultRoute:   p.DefaultRoute,
+		PacketFilterID: p.PacketFilterID,
+	}}
+}
+
 // ParseTags returns a key/value map from the instance specification.
 func ParseTags(spec instance.Spec) map[string]string {
 	tags := make(map[string]string)
@@ -38,3 +38,95 @@ func TestParseTags(t *testing.T) {
 		InfrakitSakuraCloudVersion: InfrakitSakuraCloudCurrentVersion,
 	}, tags)
 }
+
+func TestDestroyPolicy(t *testing.T) {
+	policy := DestroyPolicy{
+		Mode:        DestroyModeArchive,
+		Termination: DestroyModeKeep,
+	}
+
+	assert.Equal(t, DestroyModeArchive, policy.ModeFor(instance.RollingUpdate.Reason))
+	assert.Equal(t, DestroyModeKeep, policy.ModeFor(instance.Termination.Reason))
+	assert.Equal(t, DestroyModeArchive, policy.ModeFor(""))
+
+	assert.Equal(t, policy, ParseDestroyPolicy(policy.String()))
+
+	// instances created without policy
+	assert.Equal(t, DestroyModeDelete, ParseDestroyPolicy("").ModeFor(instance.Termination.Reason))
+}
+
+func TestParsePropertiesFor(t *testing.T) {
+	properties := types.AnyString(`{
+	  "NamePrefix": "etcd",
+	  "NetworkMode": "switch",
+	  "SwitchID": 112233445566,
+	  "IPAddress": "192.168.0.10",
+	  "Hostname": "etcd",
+	  "StartupScripts": ["echo base"],
+	  "LogicalIDOverrides": {
+	    "etcd-2": {
+	      "IPAddress": "192.168.0.12",
+	      "Hostname": "etcd-2",
+	      "Memory": 4
+	    }
+	  }
+	}`)
+
+	parsed, err := ParsePropertiesFor(properties, "etcd-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.12", parsed.IPAddress)
+	assert.Equal(t, "etcd-2", parsed.Hostname)
+	assert.Equal(t, 4, parsed.Memory)
+	assert.Equal(t, 1, parsed.Core)
+	assert.Equal(t, int64(112233445566), parsed.SwitchID)
+	assert.Equal(t, []string{"echo base"}, parsed.StartupScripts)
+
+	// without override
+	parsed, err = ParsePropertiesFor(properties, "etcd-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.10", parsed.IPAddress)
+	assert.Equal(t, "etcd", parsed.Hostname)
+	assert.Equal(t, 1, parsed.Memory)
+}
+
+func TestParsePropertiesAdditionalDisks(t *testing.T) {
+	properties := types.AnyString(`{
+	  "NamePrefix": "db",
+	  "AdditionalDisks": [
+	    {},
+	    {"Plan": "hdd", "Size": 100, "SourceDiskID": 112233445566}
+	  ]
+	}`)
+
+	parsed, err := ParseProperties(properties)
+	assert.NoError(t, err)
+	assert.Equal(t, []AdditionalDisk{
+		{Plan: "ssd", Connection: "virtio", Size: 20},
+		{Plan: "hdd", Connection: "virtio", Size: 100, SourceDiskID: 112233445566},
+	}, parsed.AdditionalDisks)
+}
+
+func TestNetworkInterfaces(t *testing.T) {
+	properties := Properties{
+		NetworkMode:    "switch",
+		SwitchID:       112233445566,
+		IPAddress:      "192.168.0.11",
+		NwMasklen:      24,
+		PacketFilterID: 112233445567,
+	}
+	assert.Equal(t, []Network{
+		{Type: "switch", SwitchID: 112233445566, IPAddress: "192.168.0.11", NwMasklen: 24, PacketFilterID: 112233445567},
+	}, properties.NetworkInterfaces())
+
+	properties = Properties{
+		NetworkMode: "shared",
+		Networks: []Network{
+			{Type: "shared"},
+			{Type: "switch", SwitchID: 112233445566, IPAddress: "192.168.0.11", NwMasklen: 24},
+		},
+	}
+	assert.Equal(t, properties.Networks, properties.NetworkInterfaces())
+
+	properties = Properties{NetworkMode: "none"}
+	assert.Empty(t, properties.NetworkInterfaces())
+}
@@ -2,6 +2,7 @@ package instance
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"strings"
 	"time"
@@ -205,3 +206,20 @@ func validateBetween(fieldName string, object interface{}, min int, max int) []e
 
 	return []error{}
 }
+
+func validateIPv4Address(fieldName string, address string) []error {
+	if address == "" {
+		return []error{}
+	}
+	if ip := net.ParseIP(address); ip == nil || ip.To4() == nil || strings.Contains(address, ":") {
+		return []error{fmt.Errorf("%q: must be an IPv4 address", fieldName)}
+	}
+	return []error{}
+}
+
+func validateMasklen(fieldName string, masklen int) []error {
+	if masklen < 0 || masklen > 32 {
+		return []error{fmt.Errorf("%q: must be between 1 and 32", fieldName)}
+	}
+	return []error{}
+}
@@ -0,0 +1,128 @@
+package instance
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+)
+
+const (
+	zoneStrategyRoundRobin     = "round-robin"
+	zoneStrategyLeastPopulated = "least-populated"
+
+	// infrakitGroupTag is the tag set by the infrakit group plugin to know which group an instance belongs to.
+	infrakitGroupTag = "infrakit.group"
+)
+
+// newInstanceID returns a zone-qualified instance ID such as "tk1a/112233445566"
+func newInstanceID(zone string, id int64) instance.ID {
+	return instance.ID(fmt.Sprintf("%s/%d", zone, id))
+}
+
+// parseInstanceID splits an instance ID into the zone and the server ID.
+// IDs without zone were issued by older versions of the plugin and belong to defaultZone.
+func parseInstanceID(id instance.ID, defaultZone string) (string, int64, error) {
+	zone := defaultZone
+	strID := string(id)
+	if i := strings.LastIndex(strID, "/"); i >= 0 {
+		zone, strID = strID[:i], strID[i+1:]
+	}
+	if zone == "" {
+		return "", 0, fmt.Errorf("Invalid instance ID %q: zone is empty", id)
+	}
+
+	serverID, err := strconv.ParseInt(strID, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid instance ID %q: %s", id, err)
+	}
+	return zone, serverID, nil
+}
+
+// clientFor returns the API client for given zone
+func (p *plugin) clientFor(zone string) (*api.Client, error) {
+	client, ok := p.clients[zone]
+	if !ok {
+		return nil, fmt.Errorf("Zone %q is not managed by this plugin", zone)
+	}
+	return client, nil
+}
+
+// resolveInstance returns the API client and the server ID for given instance ID
+func (p *plugin) resolveInstance(id instance.ID) (*api.Client, int64, error) {
+	zone, serverID, err := parseInstanceID(id, p.defaultZone)
+	if err != nil {
+		return nil, 0, err
+	}
+	client, err := p.clientFor(zone)
+	if err != nil {
+		return nil, 0, err
+	}
+	return client, serverID, nil
+}
+
+// managedZones returns sorted names of all zones managed by the plugin
+func (p *plugin) managedZones() []string {
+	zones := []string{}
+	for zone := range p.clients {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// selectZone picks the zone to create a new instance in, according to Properties.ZoneStrategy
+func (p *plugin) selectZone(properties instance_types.Properties, spec instance.Spec) (string, error) {
+	zones := properties.Zones
+	if len(zones) == 0 {
+		return p.defaultZone, nil
+	}
+
+	switch properties.ZoneStrategy {
+	case zoneStrategyLeastPopulated:
+		return p.leastPopulatedZone(zones, spec)
+	default:
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		zone := zones[p.nextZone%len(zones)]
+		p.nextZone++
+		return zone, nil
+	}
+}
+
+// leastPopulatedZone returns the zone having the fewest instances of the same group.
+// On a tie, the zone listed first wins. Zones failing to be described are skipped.
+func (p *plugin) leastPopulatedZone(zones []string, spec instance.Spec) (string, error) {
+	tags := map[string]string{}
+	if group, ok := spec.Tags[infrakitGroupTag]; ok {
+		tags[infrakitGroupTag] = group
+	}
+	_, tags = mergeTags(tags, p.namespace())
+
+	selected := ""
+	min := -1
+	errs := []error{}
+	for _, zone := range zones {
+		descriptions, err := p.describeZone(zone, tags, false, false)
+		if err != nil {
+			log.Warnf("Skipping zone %s: %s", zone, err)
+			errs = append(errs, err)
+			continue
+		}
+		log.Debugf("zone %s has %d instances", zone, len(descriptions))
+		if min < 0 || len(descriptions) < min {
+			selected = zone
+			min = len(descriptions)
+		}
+	}
+	if selected == "" {
+		return "", fmt.Errorf("No zone is available: %s", flattenErrors(errs))
+	}
+	return selected, nil
+}
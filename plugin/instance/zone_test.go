@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/docker/infrakit/pkg/types"
+	instance_types "github.com/sacloud/infrakit.sakuracloud/plugin/instance/types"
+	"github.com/sacloud/libsacloud/api"
+	"github.com/sacloud/libsacloud/sacloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseInstanceID(t *testing.T) {
+	zone, id, err := parseInstanceID(instance.ID("tk1a/112233445566"), "is1b")
+	assert.NoError(t, err)
+	assert.Equal(t, "tk1a", zone)
+	assert.Equal(t, int64(112233445566), id)
+
+	// without zone
+	zone, id, err = parseInstanceID(instance.ID("112233445566"), "is1b")
+	assert.NoError(t, err)
+	assert.Equal(t, "is1b", zone)
+	assert.Equal(t, int64(112233445566), id)
+
+	_, _, err = parseInstanceID(instance.ID("/112233445566"), "is1b")
+	assert.Error(t, err)
+
+	_, _, err = parseInstanceID(instance.ID("tk1a/foo"), "is1b")
+	assert.Error(t, err)
+}
+
+func TestNewInstanceID(t *testing.T) {
+	id := newInstanceID("tk1a", 112233445566)
+	assert.Equal(t, instance.ID("tk1a/112233445566"), id)
+
+	zone, serverID, err := parseInstanceID(id, "is1b")
+	assert.NoError(t, err)
+	assert.Equal(t, "tk1a", zone)
+	assert.Equal(t, int64(112233445566), serverID)
+}
+
+func TestProvisionUnmanagedZone(t *testing.T) {
+	p := NewSakuraCloudInstancePlugin(api.NewClient("token", "secret", "tk1a"), map[string]string{}, Options{})
+
+	_, err := p.Provision(instance.Spec{Properties: types.AnyString(`{"Zones": ["is1a"]}`)})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `Zone "is1a" is not managed by this plugin`)
+}
+
+// stubZoneServers returns the number of servers of the etcd group for each zone, and fails zones not in counts
+func stubZoneServers(counts map[string]int) func() {
+	orig := findServersPage
+	findServersPage = func(client *api.Client, serverAPI *api.ServerAPI, offset int) (*sacloud.SearchResponse, error) {
+		count, ok := counts[client.Zone]
+		if !ok {
+			return nil, errors.New("Error in response: 503 Service Unavailable")
+		}
+		servers := []sacloud.Server{}
+		for i := 0; i < count; i++ {
+			server := sacloud.Server{Resource: &sacloud.Resource{ID: int64(100000000001 + i)}}
+			server.Tags = instance_types.EncodeTags(map[string]string{
+				instance_types.InfrakitSakuraCloudVersion: instance_types.InfrakitSakuraCloudCurrentVersion,
+				infrakitGroupTag: "etcd",
+			})
+			servers = append(servers, server)
+		}
+		return &sacloud.SearchResponse{
+			Total:                   count,
+			Count:                   count,
+			SakuraCloudResourceList: &sacloud.SakuraCloudResourceList{Servers: servers},
+		}, nil
+	}
+	return func() { findServersPage = orig }
+}
+
+func TestZoneFailure(t *testing.T) {
+	p := NewSakuraCloudInstancePlugin(api.NewClient("token", "secret", "tk1a"), map[string]string{}, Options{Zones: []string{"is1a", "is1b"}}).(*plugin)
+	spec := instance.Spec{Tags: map[string]string{infrakitGroupTag: "etcd"}}
+	zones := []string{"is1a", "is1b", "tk1a"}
+
+	// is1a is down
+	restore := stubZoneServers(map[string]int{"is1b": 2, "tk1a": 1})
+	zone, err := p.leastPopulatedZone(zones, spec)
+	assert.NoError(t, err)
+	assert.Equal(t, "tk1a", zone)
+
+	descriptions, err := p.describeInstances(spec.Tags, false, false)
+	assert.NoError(t, err)
+	assert.Len(t, descriptions, 3)
+	restore()
+
+	// all zones are down
+	restore = stubZoneServers(map[string]int{})
+	defer restore()
+	_, err = p.leastPopulatedZone(zones, spec)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "No zone is available")
+
+	_, err = p.describeInstances(spec.Tags, false, false)
+	assert.Error(t, err)
+}